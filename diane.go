@@ -1,79 +1,220 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"runtime"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/giuseppe7/diane/internal"
+	"github.com/giuseppe7/diane/pkg/whois"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Variable to be set by the Go linker at build time.
+// Variables to be set by the Go linker at build time.
 var version string
+var commit string
 
-// Set up observability with Prometheus handler for metrics.
-func initObservability() {
+// Set up observability with Prometheus handler for metrics, and the health
+// and readiness endpoints.
+func initObservability(server *internal.ObservabilityServer) {
 
-	go func() {
-		http.Handle(internal.ApplicationMetricsEndpoint, promhttp.Handler())
-		http.ListenAndServe(internal.ApplicationMetricsEndpointPort, nil)
-	}()
+	go server.Serve()
 
-	// Register a version gauge.
-	versionGauge := prometheus.NewGauge(
+	// Register a build info gauge, always 1 with the build in its labels.
+	buildInfo := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: internal.ApplicationNamespace,
-			Name:      "version_info",
-			Help:      "Version of the application.",
+			Name:      "build_info",
+			Help:      "Gauge for the version, commit and Go version the application was built with.",
 		},
+		[]string{"version", "commit", "goversion"},
 	)
-	prometheus.MustRegister(versionGauge)
-	versionValue, err := strconv.ParseFloat(version, 64)
-	if err != nil {
-		versionValue = 0.0
-	}
-	versionGauge.Set(versionValue)
+	prometheus.MustRegister(buildInfo)
+	buildInfo.WithLabelValues(version, commit, runtime.Version()).Set(1)
+}
+
+// Routes the metrics, health and readiness endpoints.
+func newObservabilityHandler(readiness *internal.Readiness) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(internal.ApplicationMetricsEndpoint, promhttp.Handler())
+	mux.Handle(internal.ApplicationHealthEndpoint, internal.HealthHandler())
+	mux.Handle(internal.ApplicationReadyEndpoint, readiness.Handler())
+	return mux
 }
 
 // Obvious main function for the application.
 func main() {
-	log.Println("Coming online...")
-	log.Print(fmt.Sprintf("Version: %v\n", version))
 
-	// Channel to be aware of an OS interrupt like Control-C.
-	var waiter sync.WaitGroup
-	waiter.Add(1)
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	// Context done on an OS interrupt like Control-C, stopping the workers
+	// and cancelling their queries in flight.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var workers sync.WaitGroup
+	startWorker := func(doWork func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			doWork(ctx)
+		}()
+	}
 
-	// Load up configuration.
+	// Load up configuration, logging as configured from then on.
 	appConfig := internal.InitConfiguration()
-	log.Println(fmt.Sprintf("Loaded %d domains from configuration.", len(appConfig.Domains)))
+	logger, err := internal.NewLogger(appConfig.Logging, os.Stderr)
+	if err != nil {
+		fatal("Could not set up logging", err)
+	}
+	slog.SetDefault(logger)
+	slog.Info("Coming online", "version", version, "commit", commit)
+	slog.Info("Loaded configuration", "domains", len(appConfig.Domains))
+	shutdownTracing, err := internal.InitTracing(context.Background(), appConfig.Tracing, version)
+	if err != nil {
+		fatal("Could not set up tracing", err)
+	}
+	readiness := internal.NewReadiness()
+	readiness.ConfigLoaded()
 
-	// Set up observability.
-	initObservability()
-	log.Println("Observability endpoint available.")
+	// Set up observability, not ready until the first poll is done.
+	server, err := internal.NewObservabilityServer(appConfig.HTTP, newObservabilityHandler(readiness))
+	if err != nil {
+		fatal("Could not start the observability endpoint", err)
+	}
+	initObservability(server)
+	slog.Info("Observability endpoint available", "address", server.Addr())
 
-	// Do the work.
-	whoisWorker := internal.NewWhoisWorker(internal.ApplicationNamespace, appConfig.Domains)
-	go whoisWorker.DoWork()
+	// Notifications from the checks below go to the log and optional webhook.
+	notifier := internal.NewNotifier(appConfig.Notifications)
 
-	// Function and waiter to wait for the OS interrupt and do any clean-up.
+	store, err := internal.NewStateStore(appConfig.State.Path)
+	if err != nil {
+		fatal("Could not load the state file", err)
+	}
+	readiness.StoreOpened(store)
 	go func() {
-		<-c
-		fmt.Println("\r")
-		log.Println("Interrupt captured.")
-		waiter.Done()
+		// Write the state file now and then so a crash loses little.
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := store.Flush()
+			if err != nil {
+				slog.Error("Could not write the state file", "error", err)
+			}
+		}
 	}()
-	waiter.Wait()
 
-	// Shut down the application.
-	log.Println("Shutting down.")
+	// Do the work.
+	whoisOptions, err := internal.WhoisClientOptions(appConfig.Whois)
+	if err != nil {
+		fatal("Could not set up the whois client", err)
+	}
+	if appConfig.Whois.ReferralCache.Enabled {
+		ttl := time.Duration(appConfig.Whois.ReferralCache.TTLHours) * time.Hour
+		referralCache, err := internal.NewReferralCache(internal.ApplicationNamespace, prometheus.DefaultRegisterer, ttl, store, appConfig.Whois.ReferralCache.Prepopulate)
+		if err != nil {
+			fatal("Could not load the referral cache", err)
+		}
+		whoisOptions = append(whoisOptions, whois.WithReferralCache(referralCache))
+	}
+	whoisClient := internal.NewWhoisClient(internal.ApplicationNamespace, prometheus.DefaultRegisterer, appConfig.Whois.Metrics.LowCardinality, whoisOptions...)
+	lockPolicy := internal.NewLockPolicy(appConfig.LockPolicy.Groups)
+	whoisWorker := internal.NewWhoisWorker(internal.ApplicationNamespace, prometheus.DefaultRegisterer, whoisClient, appConfig.Domains, lockPolicy, notifier, readiness)
+	startWorker(whoisWorker.DoWork)
+
+	if appConfig.EmailAuth.Enabled {
+		pollingInterval := time.Duration(appConfig.EmailAuth.PollingIntervalMinutes) * time.Minute
+		emailAuthWorker := internal.NewEmailAuthWorker(internal.ApplicationNamespace, prometheus.DefaultRegisterer, appConfig.Domains, pollingInterval, notifier)
+		startWorker(emailAuthWorker.DoWork)
+	}
+
+	if appConfig.Takeover.Enabled {
+		checker, err := internal.NewTakeoverChecker(appConfig.Takeover.Nameserver, appConfig.Takeover.Fingerprints)
+		if err != nil {
+			fatal("Could not set up the takeover checker", err)
+		}
+		pollingInterval := time.Duration(appConfig.Takeover.PollingIntervalMinutes) * time.Minute
+		takeoverWorker := internal.NewTakeoverWorker(internal.ApplicationNamespace, prometheus.DefaultRegisterer, checker, appConfig.Takeover.Subdomains, pollingInterval, notifier)
+		startWorker(takeoverWorker.DoWork)
+	}
+
+	if appConfig.Typosquat.Enabled {
+		pollingInterval := time.Duration(appConfig.Typosquat.PollingIntervalMinutes) * time.Minute
+		queryDelay := time.Duration(appConfig.Typosquat.QueryDelayMilliseconds) * time.Millisecond
+		typosquatWorker := internal.NewTyposquatWorker(internal.ApplicationNamespace, prometheus.DefaultRegisterer, whoisClient, appConfig.Typosquat.Domains, pollingInterval, queryDelay, notifier, store)
+		startWorker(typosquatWorker.DoWork)
+	}
+
+	if appConfig.DropWatch.Enabled {
+		pollingInterval := time.Duration(appConfig.DropWatch.PollingIntervalMinutes) * time.Minute
+		dropWatchWorker := internal.NewDropWatchWorker(internal.ApplicationNamespace, prometheus.DefaultRegisterer, whoisClient, appConfig.DropWatch.Domains, pollingInterval, notifier, store)
+		startWorker(dropWatchWorker.DoWork)
+	}
+
+	if appConfig.NetworkWatch.Enabled {
+		pollingInterval := time.Duration(appConfig.NetworkWatch.PollingIntervalMinutes) * time.Minute
+		networkWatchWorker := internal.NewNetworkWatchWorker(internal.ApplicationNamespace, prometheus.DefaultRegisterer, whoisClient, appConfig.NetworkWatch.Resources, pollingInterval, notifier, store)
+		startWorker(networkWatchWorker.DoWork)
+	}
+
+	// Wait for the OS interrupt, a second one kills the application outright.
+	<-ctx.Done()
+	stop()
+	fmt.Println("\r")
+	slog.Info("Interrupt captured")
+
+	// Shut down the application, giving the workers, queued notifications and
+	// open scrapes until the deadline.
+	slog.Info("Shutting down")
+	readiness.ShuttingDown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(appConfig.ShutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+	if !waitContext(shutdownCtx, &workers) {
+		slog.Warn("Workers did not stop in time, shutting down anyway")
+	}
+	notifier.Close(shutdownCtx)
+	err = store.Flush()
+	if err != nil {
+		slog.Error("Could not write the state file", "error", err)
+	}
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Error("Could not shut down the observability endpoint", "error", err)
+	}
+	err = shutdownTracing(shutdownCtx)
+	if err != nil {
+		slog.Error("Could not export the remaining spans", "error", err)
+	}
+}
+
+// Logs the error and exits, for failures at start up.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// Waits for the group, returning false if ctx is done first.
+func waitContext(ctx context.Context, group *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
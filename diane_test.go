@@ -1,19 +1,42 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/giuseppe7/diane/internal"
 )
 
+func getEndpoint(t *testing.T, httpClient *http.Client, address string, endpoint string) (int, string) {
+	resp, err := httpClient.Get(fmt.Sprintf("http://%s%s", address, endpoint))
+	if err != nil {
+		t.Fatalf("expected to be able to reach %v: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading the response body")
+	}
+	return resp.StatusCode, string(bodyBytes)
+}
+
 func TestInitObservability(t *testing.T) {
 
-	initObservability()
+	readiness := internal.NewReadiness()
+	config := internal.InitConfiguration().HTTP
+	config.ListenAddress = "127.0.0.1:0"
+	server, err := internal.NewObservabilityServer(config, newObservabilityHandler(readiness))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	initObservability(server)
+	defer server.Shutdown(context.Background())
 
 	tr := &http.Transport{
 		MaxIdleConns:    10,
@@ -24,27 +47,34 @@ func TestInitObservability(t *testing.T) {
 		Timeout:   10 * time.Second,
 	}
 
-	url := fmt.Sprintf("http://0.0.0.0%s/%s", internal.ApplicationMetricsEndpointPort, internal.ApplicationMetricsEndpoint)
+	code, body := getEndpoint(t, httpClient, server.Addr(), internal.ApplicationMetricsEndpoint)
+	if code != 200 {
+		t.Errorf("connected but received non-200 status code %d", code)
+	}
+	r := regexp.MustCompile(`.*_build_info\{commit=".*",goversion="go.*",version=".*"\} 1`)
+	if len(r.FindStringSubmatch(body)) == 0 {
+		t.Errorf("expecting to find the build_info in the metrics endpoint")
+	}
 
-	req, _ := http.NewRequest("GET", url, nil)
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		t.Errorf("expected to be able to reach the metrics endpoint")
-		return
-	} else if resp.StatusCode != 200 {
-		t.Errorf("connected but received non-200 status code %d", resp.StatusCode)
-		return
+	if code, _ := getEndpoint(t, httpClient, server.Addr(), internal.ApplicationHealthEndpoint); code != 200 {
+		t.Errorf("expected the health endpoint to answer 200, got %d", code)
 	}
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		t.Errorf("unexpected error reading the response body")
-		return
+	if code, _ := getEndpoint(t, httpClient, server.Addr(), internal.ApplicationReadyEndpoint); code != 503 {
+		t.Errorf("expected the ready endpoint to answer 503 before the first poll, got %d", code)
 	}
+}
 
-	bodyString := string(bodyBytes)
-	r := regexp.MustCompile(`.*_version_info (\d.*?)`)
-	if len(r.FindStringSubmatch(bodyString)) == 0 {
-		t.Errorf("expecting to find the version_info in the metrics endpoint")
+func TestWaitContext(t *testing.T) {
+	var group sync.WaitGroup
+	group.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if waitContext(ctx, &group) {
+		t.Errorf("expected to give up on a group that never finishes")
+	}
+
+	group.Done()
+	if !waitContext(context.Background(), &group) {
+		t.Errorf("expected a finished group to be waited for")
 	}
-	defer resp.Body.Close()
 }
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Phases of the gTLD deletion lifecycle we track for a watched domain.
+const (
+	dropPhaseRegistered = "registered"
+	dropPhaseExpired    = "expired"
+	dropPhaseRedemption = "redemptionPeriod"
+	dropPhasePending    = "pendingDelete"
+	dropPhaseAvailable  = "available"
+)
+
+// Typical ICANN lifecycle lengths after expiry, registries may differ.
+const (
+	autoRenewGracePeriod = 45 * 24 * time.Hour
+	redemptionPeriod     = 30 * 24 * time.Hour
+	pendingDeletePeriod  = 5 * 24 * time.Hour
+)
+
+// What we remember about a watched domain between polls and restarts.
+type dropWatchState struct {
+	Phase      string    `json:"phase"`
+	PhaseSince time.Time `json:"phase_since"` // When we first saw the current phase.
+}
+
+type DropWatchWorker struct {
+	query           func(ctx context.Context, target string) whois.Response
+	domains         []string
+	pollingInterval time.Duration
+	notifier        Notifier
+	store           *StateStore
+	gaugePhase      *prometheus.GaugeVec
+	gaugeDrop       *prometheus.GaugeVec
+}
+
+func NewDropWatchWorker(applicationNamespace string, registerer prometheus.Registerer, client *whois.Client, domains []string, pollingInterval time.Duration, notifier Notifier, store *StateStore) *DropWatchWorker {
+	worker := new(DropWatchWorker)
+	worker.query = client.QueryContext
+	worker.domains = domains
+	worker.pollingInterval = pollingInterval
+	worker.notifier = notifier
+	worker.store = store
+
+	worker.gaugePhase = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "drop_watch_phase",
+			Help:      "Gauge for the lifecycle phase of a watched domain, 1 for the current phase.",
+		},
+		[]string{"domain", "phase"},
+	)
+	registerer.MustRegister(worker.gaugePhase)
+
+	worker.gaugeDrop = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "drop_watch_estimated_drop_timestamp_seconds",
+			Help:      "Gauge for the estimated time a watched domain becomes available, 0 when unknown.",
+		},
+		[]string{"domain"},
+	)
+	registerer.MustRegister(worker.gaugeDrop)
+	return worker
+}
+
+// Checks the domains every polling interval until ctx is done.
+func (worker *DropWatchWorker) DoWork(ctx context.Context) {
+	for {
+		pollCtx, span := tracer().Start(ctx, "drop_watch_worker.poll", trace.WithAttributes(attribute.Int("whois.domains", len(worker.domains))))
+		for _, domain := range worker.domains {
+			resp := worker.query(pollCtx, domain)
+			if ctx.Err() != nil {
+				span.End()
+				return
+			}
+			worker.recordResponse(domain, resp)
+		}
+		span.End()
+		err := worker.store.Flush()
+		if err != nil {
+			slog.Error("Error in flushing state", "error", err)
+		}
+		if !sleepContext(ctx, worker.pollingInterval) {
+			return
+		}
+	}
+}
+
+func dropWatchStateKey(domain string) string {
+	return "dropwatch/" + domain
+}
+
+// Works out the lifecycle phase from the response, empty if it tells us nothing.
+func dropPhase(resp whois.Response, now time.Time) string {
+	switch {
+	case resp.Status == whois.ResponseAvailable:
+		return dropPhaseAvailable
+	case resp.Status != whois.ResponseOk:
+		return ""
+	case resp.HasStatus(dropPhasePending):
+		return dropPhasePending
+	case resp.HasStatus(dropPhaseRedemption):
+		return dropPhaseRedemption
+	case resp.HasExpiration && resp.Expiration.Before(now):
+		return dropPhaseExpired
+	}
+	return dropPhaseRegistered
+}
+
+// Estimates when the domain drops, zero when it is not on its way out.
+func estimateDrop(phase string, since time.Time, resp whois.Response) time.Time {
+	switch phase {
+	case dropPhasePending:
+		return since.Add(pendingDeletePeriod)
+	case dropPhaseRedemption:
+		return since.Add(redemptionPeriod + pendingDeletePeriod)
+	case dropPhaseExpired:
+		return resp.Expiration.Add(autoRenewGracePeriod + redemptionPeriod + pendingDeletePeriod)
+	}
+	return time.Time{}
+}
+
+func (worker *DropWatchWorker) recordResponse(domain string, resp whois.Response) {
+	now := time.Now().UTC()
+	phase := dropPhase(resp, now)
+	if phase == "" {
+		// Errors and rate limits tell us nothing, keep what we know.
+		slog.Info("Queried watched domain", "domain", domain, "server", resp.HostPort, "status", resp.Status.String())
+		return
+	}
+
+	key := dropWatchStateKey(domain)
+	var state dropWatchState
+	found, err := worker.store.Get(key, &state)
+	if err != nil {
+		slog.Error("Error in reading state", "key", key, "error", err)
+	}
+	if !found || state.Phase != phase {
+		if found {
+			worker.gaugePhase.DeleteLabelValues(domain, state.Phase)
+		}
+		if phase == dropPhaseAvailable {
+			worker.notifier.Notify(NewNotification("drop_watch", domain, "domain is available", nil))
+		} else if found {
+			worker.notifier.Notify(NewNotification("drop_watch", domain, "domain entered "+phase, map[string]string{"previous": state.Phase}))
+		}
+		state = dropWatchState{Phase: phase, PhaseSince: now}
+		err = worker.store.Put(key, state)
+		if err != nil {
+			slog.Error("Error in writing state", "key", key, "error", err)
+		}
+	}
+
+	worker.gaugePhase.WithLabelValues(domain, phase).Set(1)
+	drop := estimateDrop(phase, state.PhaseSince, resp)
+	if drop.IsZero() {
+		worker.gaugeDrop.WithLabelValues(domain).Set(0)
+		slog.Info("Queried watched domain", "domain", domain, "phase", phase)
+	} else {
+		worker.gaugeDrop.WithLabelValues(domain).Set(float64(drop.Unix()))
+		slog.Info("Queried watched domain", "domain", domain, "phase", phase, "expected_drop", drop.Format("2006-01-02"))
+	}
+}
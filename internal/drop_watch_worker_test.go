@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newDropWatchTestResponse(raw string) whois.Response {
+	resp := whois.NewResponse()
+	resp.ParseRawResponse(raw)
+	return resp
+}
+
+func TestDropPhase(t *testing.T) {
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{name: "registered", raw: "Domain Name: example.com\nRegistry Expiry Date: 2022-08-13T04:00:00Z\nDomain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited\n", expected: dropPhaseRegistered},
+		{name: "expired", raw: "Domain Name: example.com\nRegistry Expiry Date: 2021-08-13T04:00:00Z\nDomain Status: autoRenewPeriod https://icann.org/epp#autoRenewPeriod\n", expected: dropPhaseExpired},
+		{name: "redemption", raw: "Domain Name: example.com\nDomain Status: redemptionPeriod https://icann.org/epp#redemptionPeriod\n", expected: dropPhaseRedemption},
+		{name: "pending", raw: "Domain Name: example.com\nDomain Status: redemptionPeriod\nDomain Status: pendingDelete\n", expected: dropPhasePending},
+		{name: "available", raw: "No match for \"EXAMPLE.COM\".\n", expected: dropPhaseAvailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if phase := dropPhase(newDropWatchTestResponse(tt.raw), now); phase != tt.expected {
+				t.Errorf("dropPhase(%s) was %v, expected %v", tt.name, phase, tt.expected)
+			}
+		})
+	}
+
+	if phase := dropPhase(whois.Response{Status: whois.ResponseError}, now); phase != "" {
+		t.Errorf("expected errors to have no phase, got %v", phase)
+	}
+}
+
+func TestDropWatchWorkerRecordResponse(t *testing.T) {
+	notifier := &recordingNotifier{}
+	store, _ := NewStateStore("")
+	worker := NewDropWatchWorker(testApplicationNamespace, prometheus.NewRegistry(), whoisClient, []string{"example.com"}, time.Minute, notifier, store)
+
+	worker.recordResponse("example.com", newDropWatchTestResponse("Domain Name: example.com\nDomain Status: redemptionPeriod\n"))
+	if len(notifier.notifications) != 0 {
+		t.Errorf("expected no notification for the first phase seen, found %d", len(notifier.notifications))
+	}
+	estimate := testutil.ToFloat64(worker.gaugeDrop.WithLabelValues("example.com"))
+	expected := time.Now().Add(redemptionPeriod + pendingDeletePeriod).Unix()
+	if estimate < float64(expected-60) || estimate > float64(expected+60) {
+		t.Errorf("estimated drop was %v, expected around %v", estimate, expected)
+	}
+
+	worker.recordResponse("example.com", whois.Response{Status: whois.ResponseError})
+	worker.recordResponse("example.com", newDropWatchTestResponse("Domain Name: example.com\nDomain Status: pendingDelete\n"))
+	worker.recordResponse("example.com", newDropWatchTestResponse("No match for \"EXAMPLE.COM\".\n"))
+	if len(notifier.notifications) != 2 {
+		t.Fatalf("expected notifications for pendingDelete and available, found %d", len(notifier.notifications))
+	} else if notifier.notifications[1].Message != "domain is available" {
+		t.Errorf("unexpected notification %+v", notifier.notifications[1])
+	}
+	if count := testutil.CollectAndCount(worker.gaugePhase); count != 1 {
+		t.Errorf("expected only the current phase to be reported, found %d series", count)
+	}
+}
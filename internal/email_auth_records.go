@@ -0,0 +1,393 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RFC 7208 section 4.6.4 caps the number of DNS querying terms per evaluation.
+const spfLookupLimit = 10
+
+// Parsed SPF record, only the parts we report on.
+type spfRecord struct {
+	raw      string   // Raw TXT record.
+	all      string   // Qualifier of the "all" mechanism, empty when missing.
+	includes []string // Domains referenced by include mechanisms.
+	redirect string   // Domain referenced by the redirect modifier.
+	lookups  int      // DNS querying terms in this record alone.
+}
+
+// Parsed DMARC record from _dmarc.<domain>.
+type dmarcRecord struct {
+	raw             string   // Raw TXT record.
+	policy          string   // Value of p=.
+	subdomainPolicy string   // Value of sp=, defaults to policy.
+	pct             int      // Value of pct=, defaults to 100.
+	rua             []string // Aggregate report targets.
+}
+
+// Parsed MTA-STS TXT record and the policy file it advertises.
+type mtaSTSRecord struct {
+	raw  string // Raw TXT record.
+	id   string // Policy id.
+	mode string // Mode from the policy file, empty if it could not be fetched.
+}
+
+// Parsed TLS-RPT record from _smtp._tls.<domain>.
+type tlsRPTRecord struct {
+	raw string   // Raw TXT record.
+	rua []string // Report targets.
+}
+
+// Splits a "tag=value; tag=value" record into lowercase tags and values.
+func parseTagList(txt string) map[string]string {
+	tags := map[string]string{}
+	for _, part := range strings.Split(txt, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	return tags
+}
+
+func splitURIs(value string) []string {
+	result := []string{}
+	for _, uri := range strings.Split(value, ",") {
+		uri = strings.TrimSpace(uri)
+		if uri != "" {
+			result = append(result, uri)
+		}
+	}
+	return result
+}
+
+func isSPF(txt string) bool {
+	lower := strings.ToLower(strings.TrimSpace(txt))
+	return lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ")
+}
+
+func parseSPF(txt string) (spfRecord, error) {
+	record := spfRecord{raw: txt}
+	if !isSPF(txt) {
+		return record, fmt.Errorf("not an spf record: %q", txt)
+	}
+
+	for _, term := range strings.Fields(txt)[1:] {
+		term = strings.ToLower(term)
+		qualifier := "+"
+		if strings.ContainsAny(term[:1], "+-~?") {
+			qualifier = term[:1]
+			term = term[1:]
+		}
+		switch {
+		case term == "all":
+			record.all = qualifier
+		case strings.HasPrefix(term, "include:"):
+			record.includes = append(record.includes, strings.TrimPrefix(term, "include:"))
+			record.lookups++
+		case strings.HasPrefix(term, "redirect="):
+			record.redirect = strings.TrimPrefix(term, "redirect=")
+			record.lookups++
+		case term == "a" || strings.HasPrefix(term, "a:") || strings.HasPrefix(term, "a/"),
+			term == "mx" || strings.HasPrefix(term, "mx:") || strings.HasPrefix(term, "mx/"),
+			term == "ptr" || strings.HasPrefix(term, "ptr:"),
+			strings.HasPrefix(term, "exists:"):
+			record.lookups++
+		}
+	}
+	return record, nil
+}
+
+// Strength of the SPF "all" qualifier, higher is stricter.
+func (r spfRecord) strength() float64 {
+	switch r.all {
+	case "-":
+		return 3
+	case "~":
+		return 2
+	case "?":
+		return 1
+	}
+	return 0
+}
+
+func parseDMARC(txt string) (dmarcRecord, error) {
+	record := dmarcRecord{raw: txt, pct: 100}
+	tags := parseTagList(txt)
+	if !strings.EqualFold(tags["v"], "DMARC1") {
+		return record, fmt.Errorf("not a dmarc record: %q", txt)
+	}
+	record.policy = strings.ToLower(tags["p"])
+	record.subdomainPolicy = record.policy
+	if sp, ok := tags["sp"]; ok {
+		record.subdomainPolicy = strings.ToLower(sp)
+	}
+	if pct, ok := tags["pct"]; ok {
+		value, err := strconv.Atoi(pct)
+		if err == nil {
+			record.pct = value
+		}
+	}
+	record.rua = splitURIs(tags["rua"])
+	return record, nil
+}
+
+// Strength of the DMARC policy, higher is stricter.
+func (r dmarcRecord) strength() float64 {
+	switch r.policy {
+	case "reject":
+		return 2
+	case "quarantine":
+		return 1
+	}
+	return 0
+}
+
+func parseMTASTS(txt string) (mtaSTSRecord, error) {
+	record := mtaSTSRecord{raw: txt}
+	tags := parseTagList(txt)
+	if !strings.EqualFold(tags["v"], "STSv1") {
+		return record, fmt.Errorf("not an mta-sts record: %q", txt)
+	}
+	record.id = tags["id"]
+	return record, nil
+}
+
+// Pulls the mode out of an MTA-STS policy file (RFC 8461 section 3.2).
+func parseMTASTSPolicyMode(policy string) string {
+	for _, line := range strings.Split(policy, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "mode") {
+			return strings.ToLower(strings.TrimSpace(kv[1]))
+		}
+	}
+	return ""
+}
+
+// Strength of the MTA-STS mode, higher is stricter.
+func (r mtaSTSRecord) strength() float64 {
+	switch r.mode {
+	case "enforce":
+		return 2
+	case "testing":
+		return 1
+	}
+	return 0
+}
+
+func parseTLSRPT(txt string) (tlsRPTRecord, error) {
+	record := tlsRPTRecord{raw: txt}
+	tags := parseTagList(txt)
+	if !strings.EqualFold(tags["v"], "TLSRPTv1") {
+		return record, fmt.Errorf("not a tls-rpt record: %q", txt)
+	}
+	record.rua = splitURIs(tags["rua"])
+	return record, nil
+}
+
+// Resolver for TXT records, satisfied by net.Resolver.
+type txtResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Result of auditing the email authentication records of a single domain.
+type EmailAuthReport struct {
+	domain     string
+	spf        *spfRecord
+	spfLookups int // DNS querying terms across the whole include tree.
+	dmarc      *dmarcRecord
+	mtaSTS     *mtaSTSRecord
+	tlsRPT     *tlsRPTRecord
+	errs       []error         // Problems found while auditing, e.g. lookup failures.
+	failed     map[string]bool // Records whose lookup failed, so nothing is known of them.
+}
+
+type EmailAuthAuditor struct {
+	resolver    txtResolver
+	fetchPolicy func(ctx context.Context, domain string) (string, error)
+}
+
+func NewEmailAuthAuditor() *EmailAuthAuditor {
+	auditor := new(EmailAuthAuditor)
+	auditor.resolver = net.DefaultResolver
+	auditor.fetchPolicy = fetchMTASTSPolicy
+	return auditor
+}
+
+// Policies are a few lines, anything much larger is no policy of ours.
+const maxMTASTSPolicySize = 64 << 10
+
+func fetchMTASTSPolicy(ctx context.Context, domain string) (string, error) {
+	url := fmt.Sprintf("https://mta-sts.%s/.well-known/mta-sts.txt", domain)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMTASTSPolicySize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxMTASTSPolicySize {
+		return "", fmt.Errorf("policy from %s is larger than %d bytes", url, maxMTASTSPolicySize)
+	}
+	return string(body), nil
+}
+
+// Looks up the TXT records for name, treating a missing name as no records.
+func (a *EmailAuthAuditor) lookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, err := a.resolver.LookupTXT(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, nil
+	}
+	return records, err
+}
+
+// Returns the first record that the matcher accepts.
+func (a *EmailAuthAuditor) findTXT(ctx context.Context, name string, matches func(string) bool) (string, bool, error) {
+	records, err := a.lookupTXT(ctx, name)
+	if err != nil {
+		return "", false, err
+	}
+	for _, record := range records {
+		if matches(record) {
+			return record, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func hasTagVersion(version string) func(string) bool {
+	return func(txt string) bool {
+		return strings.EqualFold(parseTagList(txt)["v"], version)
+	}
+}
+
+// Counts the DNS querying terms of the SPF record at domain, following
+// include and redirect terms until the lookup limit is exceeded. Path holds
+// the includes being followed down to this record, counted the lookups made
+// before it. Names included twice on different branches count twice as per
+// RFC 7208, only a name including itself is a loop.
+func (a *EmailAuthAuditor) countSPFLookups(ctx context.Context, record spfRecord, path map[string]bool, counted int) (int, error) {
+	lookups := record.lookups
+	children := append([]string{}, record.includes...)
+	if record.redirect != "" {
+		children = append(children, record.redirect)
+	}
+	for _, child := range children {
+		if counted+lookups > spfLookupLimit {
+			break
+		}
+		if path[child] {
+			return lookups, fmt.Errorf("spf include loop detected at %s", child)
+		}
+		txt, found, err := a.findTXT(ctx, child, isSPF)
+		if err != nil {
+			return lookups, err
+		}
+		if !found {
+			return lookups, fmt.Errorf("spf include %s has no spf record", child)
+		}
+		childRecord, err := parseSPF(txt)
+		if err != nil {
+			return lookups, err
+		}
+		path[child] = true
+		childLookups, err := a.countSPFLookups(ctx, childRecord, path, counted+lookups)
+		delete(path, child)
+		lookups += childLookups
+		if err != nil {
+			return lookups, err
+		}
+	}
+	return lookups, nil
+}
+
+// Audits SPF, DMARC, MTA-STS and TLS-RPT records for the domain.
+func (a *EmailAuthAuditor) Audit(ctx context.Context, domain string) EmailAuthReport {
+	report := EmailAuthReport{domain: domain, failed: map[string]bool{}}
+	domain = dnsName(domain)
+
+	txt, found, err := a.findTXT(ctx, domain, isSPF)
+	if err != nil {
+		report.errs = append(report.errs, err)
+		report.failed["spf"] = true
+	} else if found {
+		record, err := parseSPF(txt)
+		if err != nil {
+			report.errs = append(report.errs, err)
+		} else {
+			report.spf = &record
+			report.spfLookups, err = a.countSPFLookups(ctx, record, map[string]bool{domain: true}, 0)
+			if err != nil {
+				report.errs = append(report.errs, err)
+			}
+		}
+	}
+
+	txt, found, err = a.findTXT(ctx, "_dmarc."+domain, hasTagVersion("DMARC1"))
+	if err != nil {
+		report.errs = append(report.errs, err)
+		report.failed["dmarc"] = true
+	} else if found {
+		record, err := parseDMARC(txt)
+		if err != nil {
+			report.errs = append(report.errs, err)
+		} else {
+			report.dmarc = &record
+		}
+	}
+
+	txt, found, err = a.findTXT(ctx, "_mta-sts."+domain, hasTagVersion("STSv1"))
+	if err != nil {
+		report.errs = append(report.errs, err)
+		report.failed["mta_sts"] = true
+	} else if found {
+		record, err := parseMTASTS(txt)
+		if err != nil {
+			report.errs = append(report.errs, err)
+		} else {
+			policy, err := a.fetchPolicy(ctx, domain)
+			if err != nil {
+				report.errs = append(report.errs, err)
+				report.failed["mta_sts"] = true
+			} else {
+				record.mode = parseMTASTSPolicyMode(policy)
+			}
+			report.mtaSTS = &record
+		}
+	}
+
+	txt, found, err = a.findTXT(ctx, "_smtp._tls."+domain, hasTagVersion("TLSRPTv1"))
+	if err != nil {
+		report.errs = append(report.errs, err)
+		report.failed["tls_rpt"] = true
+	} else if found {
+		record, err := parseTLSRPT(txt)
+		if err != nil {
+			report.errs = append(report.errs, err)
+		} else {
+			report.tlsRPT = &record
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// In-memory TXT resolver keyed by name.
+type fakeTXTResolver map[string][]string
+
+func (f fakeTXTResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := f[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func newTestEmailAuthAuditor(records fakeTXTResolver, policy string) *EmailAuthAuditor {
+	auditor := NewEmailAuthAuditor()
+	auditor.resolver = records
+	auditor.fetchPolicy = func(ctx context.Context, domain string) (string, error) {
+		if policy == "" {
+			return "", errors.New("no policy")
+		}
+		return policy, nil
+	}
+	return auditor
+}
+
+func TestParseSPF(t *testing.T) {
+	var tests = []struct {
+		txt      string
+		all      string
+		lookups  int
+		strength float64
+	}{
+		{txt: "v=spf1 -all", all: "-", lookups: 0, strength: 3},
+		{txt: "v=spf1 include:_spf.google.com ~all", all: "~", lookups: 1, strength: 2},
+		{txt: "v=spf1 a mx ip4:192.0.2.0/24 ?all", all: "?", lookups: 2, strength: 1},
+		{txt: "v=spf1 +all", all: "+", lookups: 0, strength: 0},
+		{txt: "v=spf1 redirect=_spf.example.com", all: "", lookups: 1, strength: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.txt, func(t *testing.T) {
+			record, err := parseSPF(tt.txt)
+			if err != nil {
+				t.Errorf("parseSPF(%s) error %s", tt.txt, err.Error())
+			} else if record.all != tt.all {
+				t.Errorf("parseSPF(%s) all was %v, expected %v", tt.txt, record.all, tt.all)
+			} else if record.lookups != tt.lookups {
+				t.Errorf("parseSPF(%s) lookups was %v, expected %v", tt.txt, record.lookups, tt.lookups)
+			} else if record.strength() != tt.strength {
+				t.Errorf("parseSPF(%s) strength was %v, expected %v", tt.txt, record.strength(), tt.strength)
+			}
+		})
+	}
+
+	if _, err := parseSPF("v=spf10 -all"); err == nil {
+		t.Errorf("expected an error parsing a non spf record")
+	}
+}
+
+func TestParseDMARC(t *testing.T) {
+	record, err := parseDMARC("v=DMARC1; p=quarantine; sp=reject; pct=50; rua=mailto:a@example.com,mailto:b@example.com")
+	if err != nil {
+		t.Fatalf("parseDMARC error %s", err.Error())
+	}
+	if record.policy != "quarantine" || record.subdomainPolicy != "reject" {
+		t.Errorf("unexpected policies %v and %v", record.policy, record.subdomainPolicy)
+	} else if record.pct != 50 {
+		t.Errorf("pct was %v, expected 50", record.pct)
+	} else if len(record.rua) != 2 {
+		t.Errorf("expected two rua targets, found %v", record.rua)
+	} else if record.strength() != 1 {
+		t.Errorf("strength was %v, expected 1", record.strength())
+	}
+}
+
+func TestParseMTASTSPolicyMode(t *testing.T) {
+	policy := "version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmax_age: 86400\r\n"
+	if mode := parseMTASTSPolicyMode(policy); mode != "enforce" {
+		t.Errorf("mode was %v, expected enforce", mode)
+	}
+}
+
+func TestEmailAuthAuditorAudit(t *testing.T) {
+	records := fakeTXTResolver{
+		"example.com":            {"google-site-verification=abc", "v=spf1 include:a.example.net include:b.example.net -all"},
+		"a.example.net":          {"v=spf1 a mx include:c.example.net ~all"},
+		"b.example.net":          {"v=spf1 ip4:192.0.2.1 -all"},
+		"c.example.net":          {"v=spf1 exists:%{i}.example.net -all"},
+		"_dmarc.example.com":     {"v=DMARC1; p=reject; rua=mailto:dmarc@example.com"},
+		"_mta-sts.example.com":   {"v=STSv1; id=20210101T000000"},
+		"_smtp._tls.example.com": {"v=TLSRPTv1; rua=mailto:tlsrpt@example.com"},
+	}
+	auditor := newTestEmailAuthAuditor(records, "version: STSv1\nmode: testing\n")
+
+	report := auditor.Audit(context.Background(), "example.com")
+	if len(report.errs) != 0 {
+		t.Fatalf("unexpected errors %v", report.errs)
+	}
+	if report.spf == nil || report.dmarc == nil || report.mtaSTS == nil || report.tlsRPT == nil {
+		t.Fatalf("expected all records to be present, got %+v", report)
+	}
+	if report.spfLookups != 6 {
+		t.Errorf("spfLookups was %v, expected 6", report.spfLookups)
+	} else if report.dmarc.strength() != 2 {
+		t.Errorf("dmarc strength was %v, expected 2", report.dmarc.strength())
+	} else if report.mtaSTS.mode != "testing" {
+		t.Errorf("mta-sts mode was %v, expected testing", report.mtaSTS.mode)
+	}
+}
+
+func TestEmailAuthAuditorMissingRecords(t *testing.T) {
+	auditor := newTestEmailAuthAuditor(fakeTXTResolver{}, "")
+
+	report := auditor.Audit(context.Background(), "example.com")
+	if len(report.errs) != 0 {
+		t.Errorf("missing records should not be errors, got %v", report.errs)
+	} else if len(report.failed) != 0 {
+		t.Errorf("missing records should not be failed lookups, got %v", report.failed)
+	} else if report.spf != nil || report.dmarc != nil || report.mtaSTS != nil || report.tlsRPT != nil {
+		t.Errorf("expected no records to be present, got %+v", report)
+	}
+}
+
+func TestEmailAuthAuditorLookupLimit(t *testing.T) {
+	records := fakeTXTResolver{"example.com": {"v=spf1 include:spf0.example.com -all"}}
+	for i := 0; i < 12; i++ {
+		records[fmt.Sprintf("spf%d.example.com", i)] = []string{fmt.Sprintf("v=spf1 include:spf%d.example.com -all", i+1)}
+	}
+	auditor := newTestEmailAuthAuditor(records, "")
+
+	report := auditor.Audit(context.Background(), "example.com")
+	if report.spfLookups != spfLookupLimit+1 {
+		t.Errorf("spfLookups was %v, expected the walk to stop once it exceeds %v", report.spfLookups, spfLookupLimit)
+	} else if len(report.errs) != 0 {
+		t.Errorf("unexpected errors %v", report.errs)
+	}
+}
+
+func TestEmailAuthAuditorDiamondInclude(t *testing.T) {
+	records := fakeTXTResolver{
+		"example.com":     {"v=spf1 include:a.example.com include:b.example.com -all"},
+		"a.example.com":   {"v=spf1 include:_spf.vendor.com -all"},
+		"b.example.com":   {"v=spf1 include:_spf.vendor.com -all"},
+		"_spf.vendor.com": {"v=spf1 ip4:192.0.2.0/24 -all"},
+	}
+	auditor := newTestEmailAuthAuditor(records, "")
+
+	report := auditor.Audit(context.Background(), "example.com")
+	if len(report.errs) != 0 {
+		t.Errorf("expected no loop for an include on two branches, got %v", report.errs)
+	} else if report.spfLookups != 4 {
+		t.Errorf("spfLookups was %v, expected the shared include to count twice", report.spfLookups)
+	}
+}
+
+func TestEmailAuthAuditorIncludeLoop(t *testing.T) {
+	records := fakeTXTResolver{
+		"example.com":        {"v=spf1 include:a.example.com -all"},
+		"a.example.com":      {"v=spf1 include:example.com -all"},
+		"_dmarc.example.com": {"v=DMARC1; p=none"},
+	}
+	auditor := newTestEmailAuthAuditor(records, "")
+
+	report := auditor.Audit(context.Background(), "example.com")
+	if len(report.errs) != 1 {
+		t.Errorf("expected a single include loop error, got %v", report.errs)
+	}
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type EmailAuthWorker struct {
+	auditor         *EmailAuthAuditor
+	domains         []string
+	pollingInterval time.Duration
+	notifier        Notifier
+	gaugePresent    *prometheus.GaugeVec
+	gaugeStrength   *prometheus.GaugeVec
+	gaugeSPFLookups *prometheus.GaugeVec
+	gaugeRUATargets *prometheus.GaugeVec
+	dmarcStrength   map[string]float64 // Last DMARC strength seen per domain.
+}
+
+func NewEmailAuthWorker(applicationNamespace string, registerer prometheus.Registerer, domains []string, pollingInterval time.Duration, notifier Notifier) *EmailAuthWorker {
+	worker := new(EmailAuthWorker)
+	worker.auditor = NewEmailAuthAuditor()
+	worker.domains = domains
+	worker.pollingInterval = pollingInterval
+	worker.notifier = notifier
+	worker.dmarcStrength = map[string]float64{}
+
+	labels := []string{"domain", "record"}
+	worker.gaugePresent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "email_auth_record_present",
+			Help:      "Gauge for presence of an email authentication record (spf, dmarc, mta_sts, tls_rpt) for a domain.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugePresent)
+
+	worker.gaugeStrength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "email_auth_policy_strength",
+			Help:      "Gauge for policy strength of an email authentication record, higher is stricter.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugeStrength)
+
+	labels = []string{"domain"}
+	worker.gaugeSPFLookups = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "email_auth_spf_lookups",
+			Help:      "Gauge for DNS lookups needed to evaluate the SPF record of a domain, the limit is 10.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugeSPFLookups)
+
+	worker.gaugeRUATargets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "email_auth_dmarc_rua_targets",
+			Help:      "Gauge for number of DMARC aggregate report targets for a domain.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugeRUATargets)
+	return worker
+}
+
+// Audits the domains every polling interval until ctx is done.
+func (worker *EmailAuthWorker) DoWork(ctx context.Context) {
+	for {
+		for _, domain := range worker.domains {
+			auditCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			report := worker.auditor.Audit(auditCtx, domain)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			worker.recordReport(report)
+		}
+		if !sleepContext(ctx, worker.pollingInterval) {
+			return
+		}
+	}
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func (worker *EmailAuthWorker) recordReport(report EmailAuthReport) {
+	for _, err := range report.errs {
+		slog.Warn("Error in email auth audit", "domain", report.domain, "error", err)
+	}
+
+	// A record whose lookup failed keeps the values of the last audit, a
+	// transient DNS error is no downgrade.
+	domain := report.domain
+	present := map[string]bool{
+		"spf":     report.spf != nil,
+		"dmarc":   report.dmarc != nil,
+		"mta_sts": report.mtaSTS != nil,
+		"tls_rpt": report.tlsRPT != nil,
+	}
+	for _, record := range []string{"spf", "dmarc", "mta_sts", "tls_rpt"} {
+		if !report.failed[record] {
+			worker.gaugePresent.WithLabelValues(domain, record).Set(boolToFloat(present[record]))
+		}
+	}
+
+	spfStrength, dmarcStrength, mtaSTSStrength := 0.0, 0.0, 0.0
+	ruaTargets := 0
+	if report.spf != nil {
+		spfStrength = report.spf.strength()
+	}
+	if report.dmarc != nil {
+		dmarcStrength = report.dmarc.strength()
+		ruaTargets = len(report.dmarc.rua)
+	}
+	if report.mtaSTS != nil {
+		mtaSTSStrength = report.mtaSTS.strength()
+	}
+	if !report.failed["spf"] {
+		worker.gaugeStrength.WithLabelValues(domain, "spf").Set(spfStrength)
+		worker.gaugeSPFLookups.WithLabelValues(domain).Set(float64(report.spfLookups))
+	}
+	if !report.failed["mta_sts"] {
+		worker.gaugeStrength.WithLabelValues(domain, "mta_sts").Set(mtaSTSStrength)
+	}
+	if !report.failed["dmarc"] {
+		worker.gaugeStrength.WithLabelValues(domain, "dmarc").Set(dmarcStrength)
+		worker.gaugeRUATargets.WithLabelValues(domain).Set(float64(ruaTargets))
+
+		if previous, ok := worker.dmarcStrength[domain]; ok && dmarcStrength < previous {
+			worker.notifier.Notify(NewNotification("email_auth", domain, "DMARC policy was downgraded", map[string]string{
+				"previous_strength": fmt.Sprint(previous),
+				"strength":          fmt.Sprint(dmarcStrength),
+			}))
+		}
+		worker.dmarcStrength[domain] = dmarcStrength
+	}
+
+	if report.spfLookups > spfLookupLimit {
+		slog.Warn("SPF record needs too many lookups", "domain", domain, "lookups", report.spfLookups, "limit", spfLookupLimit)
+	}
+	slog.Info("Audited email auth", "domain", domain, "spf", spfStrength, "dmarc", dmarcStrength, "mta_sts", mtaSTSStrength)
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEmailAuthWorkerRecordReport(t *testing.T) {
+	worker := NewEmailAuthWorker(testApplicationNamespace, prometheus.NewRegistry(), []string{"example.com"}, time.Minute, logNotifier{})
+
+	report := EmailAuthReport{
+		domain:     "example.com",
+		spf:        &spfRecord{all: "-"},
+		spfLookups: 3,
+		dmarc:      &dmarcRecord{policy: "reject", rua: []string{"mailto:a@example.com"}},
+	}
+	worker.recordReport(report)
+
+	if value := testutil.ToFloat64(worker.gaugePresent.WithLabelValues("example.com", "dmarc")); value != 1 {
+		t.Errorf("expected dmarc to be present, got %v", value)
+	} else if value := testutil.ToFloat64(worker.gaugePresent.WithLabelValues("example.com", "mta_sts")); value != 0 {
+		t.Errorf("expected mta_sts to be missing, got %v", value)
+	} else if value := testutil.ToFloat64(worker.gaugeStrength.WithLabelValues("example.com", "spf")); value != 3 {
+		t.Errorf("expected spf strength of 3, got %v", value)
+	} else if value := testutil.ToFloat64(worker.gaugeSPFLookups.WithLabelValues("example.com")); value != 3 {
+		t.Errorf("expected 3 spf lookups, got %v", value)
+	}
+
+	// A downgraded policy is tracked for the next comparison.
+	report.dmarc = &dmarcRecord{policy: "none"}
+	worker.recordReport(report)
+	if worker.dmarcStrength["example.com"] != 0 {
+		t.Errorf("expected dmarc strength to be downgraded to 0, got %v", worker.dmarcStrength["example.com"])
+	}
+}
+
+func TestEmailAuthWorkerRecordReportLookupError(t *testing.T) {
+	notifier := &recordingNotifier{}
+	worker := NewEmailAuthWorker(testApplicationNamespace, prometheus.NewRegistry(), []string{"example.com"}, time.Minute, notifier)
+
+	report := EmailAuthReport{domain: "example.com", dmarc: &dmarcRecord{policy: "reject"}}
+	worker.recordReport(report)
+	strength := worker.dmarcStrength["example.com"]
+
+	// A failed lookup of the DMARC record is no downgrade.
+	report = EmailAuthReport{
+		domain: "example.com",
+		errs:   []error{errors.New("lookup _dmarc.example.com: i/o timeout")},
+		failed: map[string]bool{"dmarc": true},
+	}
+	worker.recordReport(report)
+	if len(notifier.notifications) != 0 {
+		t.Errorf("expected no notification for a lookup error, found %+v", notifier.notifications)
+	} else if worker.dmarcStrength["example.com"] != strength {
+		t.Errorf("expected the previous dmarc strength %v to be kept, got %v", strength, worker.dmarcStrength["example.com"])
+	} else if value := testutil.ToFloat64(worker.gaugePresent.WithLabelValues("example.com", "dmarc")); value != 1 {
+		t.Errorf("expected dmarc to stay present, got %v", value)
+	} else if value := testutil.ToFloat64(worker.gaugeStrength.WithLabelValues("example.com", "dmarc")); value != strength {
+		t.Errorf("expected the dmarc strength gauge to stay at %v, got %v", strength, value)
+	}
+
+	report.failed = map[string]bool{}
+	worker.recordReport(report)
+	if len(notifier.notifications) != 1 {
+		t.Errorf("expected a notification once the record is gone, found %d", len(notifier.notifications))
+	}
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Endpoints for orchestrators to check on the application.
+const ApplicationHealthEndpoint = "/healthz"
+const ApplicationReadyEndpoint = "/readyz"
+
+// Tracks whether the application is ready to serve: configuration loaded,
+// first poll of the domains done and the state store writable.
+type Readiness struct {
+	mutex        sync.Mutex
+	configLoaded bool
+	polled       bool
+	shuttingDown bool
+	store        *StateStore
+}
+
+func NewReadiness() *Readiness {
+	return new(Readiness)
+}
+
+func (r *Readiness) ConfigLoaded() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.configLoaded = true
+}
+
+// Checks the store is writable from now on.
+func (r *Readiness) StoreOpened(store *StateStore) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.store = store
+}
+
+// Called by the whois worker after each poll of the domains.
+func (r *Readiness) PollCompleted() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.polled = true
+}
+
+// Called once the application starts shutting down, so no new traffic is
+// routed to it.
+func (r *Readiness) ShuttingDown() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.shuttingDown = true
+}
+
+// Returns what keeps the application from being ready, empty when ready.
+func (r *Readiness) problems() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	problems := []string{}
+	if !r.configLoaded {
+		problems = append(problems, "configuration not loaded")
+	}
+	if !r.polled {
+		problems = append(problems, "first poll not completed")
+	}
+	if r.shuttingDown {
+		problems = append(problems, "shutting down")
+	}
+	if r.store == nil {
+		problems = append(problems, "state store not opened")
+	} else if err := r.store.Check(); err != nil {
+		problems = append(problems, "state store not writable: "+err.Error())
+	}
+	return problems
+}
+
+// Answers 200 as long as the process is serving HTTP.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// Answers 200 when ready and 503 listing the problems otherwise.
+func (r *Readiness) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		problems := r.problems()
+		if len(problems) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, strings.Join(problems, "\n"))
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
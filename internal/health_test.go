@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	HealthHandler().ServeHTTP(recorder, httptest.NewRequest("GET", ApplicationHealthEndpoint, nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", recorder.Code)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	readiness := NewReadiness()
+	ready := func() (int, string) {
+		recorder := httptest.NewRecorder()
+		readiness.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", ApplicationReadyEndpoint, nil))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, "configuration not loaded") {
+		t.Errorf("expected not ready before loading the configuration, got %d %v", code, body)
+	}
+	readiness.ConfigLoaded()
+	store, _ := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+	readiness.StoreOpened(store)
+	if code, body := ready(); code != http.StatusServiceUnavailable || strings.TrimSpace(body) != "first poll not completed" {
+		t.Errorf("expected to wait for the first poll, got %d %v", code, body)
+	}
+	readiness.PollCompleted()
+	if code, body := ready(); code != http.StatusOK {
+		t.Errorf("expected to be ready, got %d %v", code, body)
+	}
+	readiness.ShuttingDown()
+	if code, body := ready(); code != http.StatusServiceUnavailable || strings.TrimSpace(body) != "shutting down" {
+		t.Errorf("expected not ready while shutting down, got %d %v", code, body)
+	}
+
+	missing, _ := NewStateStore(filepath.Join(t.TempDir(), "missing", "state.json"))
+	readiness.StoreOpened(missing)
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, "state store not writable") {
+		t.Errorf("expected an unwritable store to fail readiness, got %d %v", code, body)
+	}
+}
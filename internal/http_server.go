@@ -0,0 +1,228 @@
+package internal
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings for the HTTP server exposing metrics, health and readiness.
+type httpConfiguration struct {
+	ListenAddress string                `yaml:"listen_address" mapstructure:"listen_address"`
+	TLS           httpTLSConfiguration  `yaml:"tls" mapstructure:"tls"`
+	Auth          httpAuthConfiguration `yaml:"auth" mapstructure:"auth"`
+}
+
+type httpTLSConfiguration struct {
+	CertFile     string `yaml:"cert_file" mapstructure:"cert_file"`
+	KeyFile      string `yaml:"key_file" mapstructure:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file" mapstructure:"client_ca_file"` // Requires client certificates signed by it when set.
+}
+
+type httpAuthConfiguration struct {
+	Username    string `yaml:"username" mapstructure:"username"`
+	Password    string `yaml:"password" mapstructure:"password"`
+	BearerToken string `yaml:"bearer_token" mapstructure:"bearer_token"`
+}
+
+// Time a client gets to send the request headers, so slow clients cannot hold
+// connections open indefinitely.
+const httpReadHeaderTimeout = 10 * time.Second
+
+// HTTP server bound to its listener, so a port in use fails at start up
+// rather than in the background.
+type ObservabilityServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+func NewObservabilityServer(config httpConfiguration, handler http.Handler) (*ObservabilityServer, error) {
+	server := new(ObservabilityServer)
+	server.server = &http.Server{Handler: withAuth(config.Auth, handler), ReadHeaderTimeout: httpReadHeaderTimeout}
+
+	// Client certificates are asked for in the TLS handshake, there is none
+	// without a certificate of our own.
+	if config.TLS.ClientCAFile != "" && (config.TLS.CertFile == "" || config.TLS.KeyFile == "") {
+		return nil, fmt.Errorf("http.tls.client_ca_file requires http.tls.cert_file and http.tls.key_file")
+	}
+	if config.TLS.CertFile != "" || config.TLS.KeyFile != "" {
+		tlsConfig, err := newServerTLSConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		server.server.TLSConfig = tlsConfig
+	}
+
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %v: %w", config.ListenAddress, err)
+	}
+	if server.server.TLSConfig != nil {
+		listener = tls.NewListener(listener, server.server.TLSConfig)
+	}
+	server.listener = listener
+	return server, nil
+}
+
+// Address actually listened on, useful when the port was 0.
+func (s *ObservabilityServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Serves until Shutdown, meant to run in its own goroutine.
+func (s *ObservabilityServer) Serve() {
+	err := s.server.Serve(s.listener)
+	if err != nil && err != http.ErrServerClosed {
+		slog.Error("Observability server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func (s *ObservabilityServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Endpoints orchestrators probe without credentials.
+var unauthenticatedEndpoints = map[string]bool{
+	ApplicationHealthEndpoint: true,
+	ApplicationReadyEndpoint:  true,
+}
+
+// Requires basic or bearer credentials when configured, either is accepted
+// when both are.
+func withAuth(config httpAuthConfiguration, handler http.Handler) http.Handler {
+	if config.Username == "" && config.BearerToken == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if unauthenticatedEndpoints[req.URL.Path] || authorized(config, req) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		if config.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="diane"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func authorized(config httpAuthConfiguration, req *http.Request) bool {
+	if config.Username != "" {
+		username, password, ok := req.BasicAuth()
+		if ok && secureEqual(username, config.Username) && secureEqual(password, config.Password) {
+			return true
+		}
+	}
+	if config.BearerToken != "" {
+		header := req.Header.Get("Authorization")
+		if strings.HasPrefix(header, "Bearer ") && secureEqual(strings.TrimPrefix(header, "Bearer "), config.BearerToken) {
+			return true
+		}
+	}
+	return false
+}
+
+func secureEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func newServerTLSConfig(config httpTLSConfiguration) (*tls.Config, error) {
+	reloader, err := newCertReloader(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// Serves the certificate from disk, loading it again once the files change
+// so rotated certificates are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time // Latest modification time of the two files when loaded.
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := new(certReloader)
+	reloader.certFile = certFile
+	reloader.keyFile = keyFile
+	modTime, err := reloader.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	err = reloader.load(modTime)
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	latest := time.Time{}
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+func (r *certReloader) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	modTime, err := r.latestModTime()
+	if err == nil && !modTime.Equal(r.modTime) {
+		err = r.load(modTime)
+		if err == nil {
+			slog.Info("Reloaded the TLS certificate", "file", r.certFile)
+		}
+	}
+	if err != nil {
+		// Half written files during a rotation, keep serving the old one.
+		slog.Error("Error in reloading the TLS certificate", "file", r.certFile, "error", err)
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWithAuth(t *testing.T) {
+	handler := withAuth(httpAuthConfiguration{Username: "prometheus", Password: "secret", BearerToken: "token"},
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	var tests = []struct {
+		name     string
+		path     string
+		header   string
+		expected int
+	}{
+		{name: "anonymous", path: ApplicationMetricsEndpoint, expected: http.StatusUnauthorized},
+		{name: "basic", path: ApplicationMetricsEndpoint, header: "Basic cHJvbWV0aGV1czpzZWNyZXQ=", expected: http.StatusOK},
+		{name: "wrong password", path: ApplicationMetricsEndpoint, header: "Basic cHJvbWV0aGV1czp3cm9uZw==", expected: http.StatusUnauthorized},
+		{name: "bearer", path: ApplicationMetricsEndpoint, header: "Bearer token", expected: http.StatusOK},
+		{name: "wrong bearer", path: ApplicationMetricsEndpoint, header: "Bearer other", expected: http.StatusUnauthorized},
+		{name: "health", path: ApplicationHealthEndpoint, expected: http.StatusOK},
+		{name: "ready", path: ApplicationReadyEndpoint, expected: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestObservabilityServerPortInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	defer listener.Close()
+
+	_, err = NewObservabilityServer(httpConfiguration{ListenAddress: listener.Addr().String()}, http.NotFoundHandler())
+	if err == nil {
+		t.Errorf("expected an error binding a port in use")
+	}
+}
+
+func TestObservabilityServerClientCAWithoutCertificate(t *testing.T) {
+	config := httpConfiguration{ListenAddress: "127.0.0.1:0", TLS: httpTLSConfiguration{ClientCAFile: "ca.pem"}}
+	if _, err := NewObservabilityServer(config, http.NotFoundHandler()); err == nil {
+		t.Errorf("expected an error for a client CA without a certificate and key")
+	}
+}
+
+func TestObservabilityServerReadHeaderTimeout(t *testing.T) {
+	server, err := NewObservabilityServer(httpConfiguration{ListenAddress: "127.0.0.1:0"}, http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	defer server.listener.Close()
+	if server.server.ReadHeaderTimeout != httpReadHeaderTimeout {
+		t.Errorf("expected a read header timeout of %v, found %v", httpReadHeaderTimeout, server.server.ReadHeaderTimeout)
+	}
+}
+
+// Writes a self-signed certificate for 127.0.0.1 with the common name.
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
+
+// Common name of the certificate the server presents.
+func servedCommonName(t *testing.T, address string, clientCerts []tls.Certificate) (string, error) {
+	conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true, Certificates: clientCerts})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// Client certificates are only checked once the handshake completes.
+	err = conn.Handshake()
+	if err == nil {
+		_, err = conn.Write([]byte("GET /healthz HTTP/1.0\r\n\r\n"))
+	}
+	if err == nil {
+		_, err = conn.Read(make([]byte, 1))
+	}
+	if err != nil {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestObservabilityServerTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, certFile, keyFile, "first")
+
+	server, err := NewObservabilityServer(httpConfiguration{
+		ListenAddress: "127.0.0.1:0",
+		TLS:           httpTLSConfiguration{CertFile: certFile, KeyFile: keyFile},
+	}, HealthHandler())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	go server.Serve()
+	defer server.Shutdown(context.Background())
+
+	if name, err := servedCommonName(t, server.Addr(), nil); err != nil || name != "first" {
+		t.Fatalf("expected the first certificate, got %v error %v", name, err)
+	}
+
+	// Rotate, making sure the modification time moves on.
+	writeTestCertificate(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if name, err := servedCommonName(t, server.Addr(), nil); err != nil || name != "second" {
+		t.Errorf("expected the rotated certificate, got %v error %v", name, err)
+	}
+}
+
+func TestObservabilityServerClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, certFile, keyFile, "server")
+	clientCertFile := filepath.Join(dir, "client.crt")
+	clientKeyFile := filepath.Join(dir, "client.key")
+	writeTestCertificate(t, clientCertFile, clientKeyFile, "client")
+
+	server, err := NewObservabilityServer(httpConfiguration{
+		ListenAddress: "127.0.0.1:0",
+		TLS:           httpTLSConfiguration{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCertFile},
+	}, HealthHandler())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	go server.Serve()
+	defer server.Shutdown(context.Background())
+
+	if _, err := servedCommonName(t, server.Addr(), nil); err == nil {
+		t.Errorf("expected clients without a certificate to be refused")
+	}
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if _, err := servedCommonName(t, server.Addr(), []tls.Certificate{clientCert}); err != nil {
+		t.Errorf("expected clients with a certificate signed by the CA to be served, got %v", err)
+	}
+}
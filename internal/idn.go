@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"github.com/giuseppe7/diane/pkg/whois"
+)
+
+// Settings for internationalised domain names, always queried in punycode.
+type idnConfiguration struct {
+	// Shows them in Unicode in logs, metrics and notifications, punycode
+	// otherwise.
+	Unicode bool `yaml:"unicode" mapstructure:"unicode"`
+}
+
+// Form of a domain shown in logs, metrics and notifications. Fails for names
+// that are not valid domains.
+func displayDomain(config idnConfiguration, domain string) (string, error) {
+	ascii, err := whois.ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+	if config.Unicode {
+		return whois.ToUnicode(ascii), nil
+	}
+	return ascii, nil
+}
+
+// Name to look up in the DNS, punycode for Unicode labels. Names the IDNA
+// rules refuse, e.g. with underscores, are looked up as given.
+func dnsName(domain string) string {
+	ascii, err := whois.ToASCII(domain)
+	if err != nil {
+		return domain
+	}
+	return ascii
+}
+
+// Validates the configured domains and brings them to their display form, so
+// every worker labels a domain the same way whatever form it was given in.
+// Lookalikes are generated in punycode, so the allow lists are kept in it.
+func normalizeDomains(c *configuration) error {
+	var err error
+	for _, domains := range [][]string{c.Domains, c.DropWatch.Domains} {
+		for i := range domains {
+			domains[i], err = displayDomain(c.IDN, domains[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, group := range c.LockPolicy.Groups {
+		for i := range group.Domains {
+			group.Domains[i], err = displayDomain(c.IDN, group.Domains[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for i := range c.Takeover.Subdomains {
+		if display, err := displayDomain(c.IDN, c.Takeover.Subdomains[i]); err == nil {
+			c.Takeover.Subdomains[i] = display
+		}
+	}
+	for i := range c.Typosquat.Domains {
+		typosquat := &c.Typosquat.Domains[i]
+		typosquat.Domain, err = displayDomain(c.IDN, typosquat.Domain)
+		if err != nil {
+			return err
+		}
+		for j := range typosquat.Allow {
+			typosquat.Allow[j], err = whois.ToASCII(typosquat.Allow[j])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+)
+
+func newIDNTestConfiguration() configuration {
+	var c configuration
+	c.Domains = []string{"Müller.de", "xn--r8jz45g.jp", "example.com"}
+	c.DropWatch.Domains = []string{"bücher.example"}
+	c.LockPolicy.Groups = []lockPolicyGroup{{Name: "production", Domains: []string{"xn--mller-kva.de"}}}
+	c.Takeover.Subdomains = []string{"www.müller.de", "_acme.example.com"}
+	c.Typosquat.Domains = []typosquatDomainConfiguration{{Domain: "müller.de", Allow: []string{"müller.com"}}}
+	return c
+}
+
+func TestNormalizeDomains(t *testing.T) {
+	c := newIDNTestConfiguration()
+	c.IDN.Unicode = true
+	if err := normalizeDomains(&c); err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if expected := []string{"müller.de", "例え.jp", "example.com"}; !reflect.DeepEqual(c.Domains, expected) {
+		t.Errorf("domains were %v, expected %v", c.Domains, expected)
+	} else if c.DropWatch.Domains[0] != "bücher.example" {
+		t.Errorf("drop watch domain was %v, expected it in Unicode", c.DropWatch.Domains[0])
+	} else if c.LockPolicy.Groups[0].Domains[0] != "müller.de" {
+		t.Errorf("lock policy domain was %v, expected it in Unicode", c.LockPolicy.Groups[0].Domains[0])
+	} else if expected := []string{"www.müller.de", "_acme.example.com"}; !reflect.DeepEqual(c.Takeover.Subdomains, expected) {
+		t.Errorf("takeover subdomains were %v, expected %v", c.Takeover.Subdomains, expected)
+	} else if c.Typosquat.Domains[0].Domain != "müller.de" || c.Typosquat.Domains[0].Allow[0] != "xn--mller-kva.com" {
+		t.Errorf("typosquat domains were %+v, expected the domain in Unicode and the allow list in punycode", c.Typosquat.Domains[0])
+	}
+
+	c = newIDNTestConfiguration()
+	if err := normalizeDomains(&c); err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if expected := []string{"xn--mller-kva.de", "xn--r8jz45g.jp", "example.com"}; !reflect.DeepEqual(c.Domains, expected) {
+		t.Errorf("domains were %v, expected %v", c.Domains, expected)
+	}
+
+	for _, invalid := range []string{"exa_mple.com", "-example.com", "example..com"} {
+		c = newIDNTestConfiguration()
+		c.Domains = append(c.Domains, invalid)
+		if err := normalizeDomains(&c); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestLockPolicyMatchesIDN(t *testing.T) {
+	policy := NewLockPolicy([]lockPolicyGroup{{Name: "production", Domains: []string{"xn--mller-kva.de"}}})
+	resp := whois.ParseResponse("Domain Name: müller.de\n")
+	if results := policy.evaluate("Müller.de", resp); len(results) != 1 {
+		t.Errorf("expected the Unicode form to match the punycode form in the group, got %v", results)
+	}
+}
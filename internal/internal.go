@@ -1,8 +1,13 @@
 package internal
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
 
+	"github.com/giuseppe7/diane/pkg/whois"
 	"github.com/spf13/viper"
 )
 
@@ -12,7 +17,142 @@ const ApplicationMetricsEndpointPort = ":2112"
 
 // Structure for parsed yaml configuration.
 type configuration struct {
-	Domains []string `yaml:"domains"`
+	Domains       []string                   `yaml:"domains"`
+	Whois         whoisConfiguration         `yaml:"whois" mapstructure:"whois"`
+	LockPolicy    lockPolicyConfiguration    `yaml:"lock_policy" mapstructure:"lock_policy"`
+	EmailAuth     emailAuthConfiguration     `yaml:"email_auth" mapstructure:"email_auth"`
+	Takeover      takeoverConfiguration      `yaml:"takeover" mapstructure:"takeover"`
+	Typosquat     typosquatConfiguration     `yaml:"typosquat" mapstructure:"typosquat"`
+	DropWatch     dropWatchConfiguration     `yaml:"drop_watch" mapstructure:"drop_watch"`
+	NetworkWatch  networkWatchConfiguration  `yaml:"network_watch" mapstructure:"network_watch"`
+	Notifications notificationsConfiguration `yaml:"notifications" mapstructure:"notifications"`
+	State         stateConfiguration         `yaml:"state" mapstructure:"state"`
+	HTTP          httpConfiguration          `yaml:"http" mapstructure:"http"`
+	Logging       loggingConfiguration       `yaml:"logging" mapstructure:"logging"`
+	Tracing       tracingConfiguration       `yaml:"tracing" mapstructure:"tracing"`
+	IDN           idnConfiguration           `yaml:"idn" mapstructure:"idn"`
+	// How long in-flight checks and queued notifications get on shutdown.
+	ShutdownTimeoutSeconds int `yaml:"shutdown_timeout_seconds" mapstructure:"shutdown_timeout_seconds"`
+}
+
+// Settings for which whois servers are asked and how to reach them.
+type whoisConfiguration struct {
+	RootServer    string                       `yaml:"root_server" mapstructure:"root_server"`
+	Servers       []whoisServerConfiguration   `yaml:"servers" mapstructure:"servers"`
+	Ports         []whoisPortConfiguration     `yaml:"ports" mapstructure:"ports"`
+	Encodings     []whoisEncodingConfiguration `yaml:"encodings" mapstructure:"encodings"`
+	Templates     []whoisTemplateConfiguration `yaml:"query_templates" mapstructure:"query_templates"`
+	Dialer        dialerConfiguration          `yaml:"dialer" mapstructure:"dialer"`
+	ReferralCache referralCacheConfiguration   `yaml:"referral_cache" mapstructure:"referral_cache"`
+	Metrics       whoisMetricsConfiguration    `yaml:"metrics" mapstructure:"metrics"`
+	Retry         whoisRetryConfiguration      `yaml:"retry" mapstructure:"retry"`
+	Response      whoisResponseConfiguration   `yaml:"response" mapstructure:"response"`
+}
+
+// Settings for protecting against misbehaving servers.
+type whoisResponseConfiguration struct {
+	MaxBytes           int `yaml:"max_bytes" mapstructure:"max_bytes"`
+	ReadTimeoutSeconds int `yaml:"read_timeout_seconds" mapstructure:"read_timeout_seconds"`
+}
+
+// Settings for asking a whois server again after a transient failure.
+type whoisRetryConfiguration struct {
+	MaxAttempts            int      `yaml:"max_attempts" mapstructure:"max_attempts"`
+	BackoffMilliseconds    int      `yaml:"backoff_milliseconds" mapstructure:"backoff_milliseconds"`
+	MaxBackoffMilliseconds int      `yaml:"max_backoff_milliseconds" mapstructure:"max_backoff_milliseconds"`
+	RetryableErrors        []string `yaml:"retryable_errors" mapstructure:"retryable_errors"`
+	RetryableStatuses      []string `yaml:"retryable_statuses" mapstructure:"retryable_statuses"`
+}
+
+// Settings for the metrics of the whois client.
+type whoisMetricsConfiguration struct {
+	LowCardinality bool `yaml:"low_cardinality" mapstructure:"low_cardinality"`
+}
+
+// Settings for caching the referrals of the root server per TLD.
+type referralCacheConfiguration struct {
+	Enabled     bool `yaml:"enabled" mapstructure:"enabled"`
+	TTLHours    int  `yaml:"ttl_hours" mapstructure:"ttl_hours"`
+	Prepopulate bool `yaml:"prepopulate" mapstructure:"prepopulate"`
+}
+
+// Server to ask directly for a TLD.
+type whoisServerConfiguration struct {
+	TLD    string `yaml:"tld" mapstructure:"tld"`
+	Server string `yaml:"server" mapstructure:"server"`
+}
+
+// Port of a server that does not listen on 43.
+type whoisPortConfiguration struct {
+	Server string `yaml:"server" mapstructure:"server"`
+	Port   int    `yaml:"port" mapstructure:"port"`
+}
+
+// Encoding of the responses of a server, e.g. euc-kr.
+type whoisEncodingConfiguration struct {
+	Server   string `yaml:"server" mapstructure:"server"`
+	Encoding string `yaml:"encoding" mapstructure:"encoding"`
+}
+
+// Query syntax of a server, e.g. "-T dn,ace {query}".
+type whoisTemplateConfiguration struct {
+	Server   string `yaml:"server" mapstructure:"server"`
+	Template string `yaml:"template" mapstructure:"template"`
+}
+
+// Settings for opening connections to whois servers.
+type dialerConfiguration struct {
+	TimeoutSeconds int    `yaml:"timeout_seconds" mapstructure:"timeout_seconds"`
+	SourceAddress  string `yaml:"source_address" mapstructure:"source_address"`
+	SOCKS5Proxy    string `yaml:"socks5_proxy" mapstructure:"socks5_proxy"`
+	SOCKS5Username string `yaml:"socks5_username" mapstructure:"socks5_username"`
+	SOCKS5Password string `yaml:"socks5_password" mapstructure:"socks5_password"`
+}
+
+// Settings for the EPP statuses required on groups of domains.
+type lockPolicyConfiguration struct {
+	Groups []lockPolicyGroup `yaml:"groups" mapstructure:"groups"`
+}
+
+// Settings for auditing SPF, DMARC, MTA-STS and TLS-RPT records.
+type emailAuthConfiguration struct {
+	Enabled                bool `yaml:"enabled" mapstructure:"enabled"`
+	PollingIntervalMinutes int  `yaml:"polling_interval_minutes" mapstructure:"polling_interval_minutes"`
+}
+
+// Settings for detecting dangling CNAME records on subdomains.
+type takeoverConfiguration struct {
+	Enabled                bool                  `yaml:"enabled" mapstructure:"enabled"`
+	PollingIntervalMinutes int                   `yaml:"polling_interval_minutes" mapstructure:"polling_interval_minutes"`
+	Nameserver             string                `yaml:"nameserver" mapstructure:"nameserver"`
+	Subdomains             []string              `yaml:"subdomains" mapstructure:"subdomains"`
+	Fingerprints           []takeoverFingerprint `yaml:"fingerprints" mapstructure:"fingerprints"`
+}
+
+// Settings for watching registrations of lookalike domains.
+type typosquatConfiguration struct {
+	Enabled                bool                           `yaml:"enabled" mapstructure:"enabled"`
+	PollingIntervalMinutes int                            `yaml:"polling_interval_minutes" mapstructure:"polling_interval_minutes"`
+	QueryDelayMilliseconds int                            `yaml:"query_delay_milliseconds" mapstructure:"query_delay_milliseconds"`
+	Domains                []typosquatDomainConfiguration `yaml:"domains" mapstructure:"domains"`
+}
+
+// Settings for watching domains we want to acquire until they drop.
+type dropWatchConfiguration struct {
+	Enabled                bool     `yaml:"enabled" mapstructure:"enabled"`
+	PollingIntervalMinutes int      `yaml:"polling_interval_minutes" mapstructure:"polling_interval_minutes"`
+	Domains                []string `yaml:"domains" mapstructure:"domains"`
+}
+
+// Settings for where notifications are sent besides the log.
+type notificationsConfiguration struct {
+	WebhookURL string `yaml:"webhook_url" mapstructure:"webhook_url"`
+	QueueSize  int    `yaml:"queue_size" mapstructure:"queue_size"`
+}
+
+// Settings for state kept across restarts.
+type stateConfiguration struct {
+	Path string `yaml:"path" mapstructure:"path"`
 }
 
 func InitConfiguration() configuration {
@@ -21,14 +161,91 @@ func InitConfiguration() configuration {
 	viper.AddConfigPath("/etc/diane/")
 	viper.AddConfigPath("./configs/")
 	viper.AddConfigPath("../configs/")
+	viper.SetDefault("whois.root_server", "whois.iana.org")
+	viper.SetDefault("whois.dialer.timeout_seconds", 10)
+	viper.SetDefault("whois.referral_cache.ttl_hours", 168)
+	retryPolicy := whois.DefaultRetryPolicy()
+	viper.SetDefault("whois.retry.max_attempts", retryPolicy.MaxAttempts)
+	viper.SetDefault("whois.retry.backoff_milliseconds", retryPolicy.Backoff.Milliseconds())
+	viper.SetDefault("whois.retry.max_backoff_milliseconds", retryPolicy.MaxBackoff.Milliseconds())
+	viper.SetDefault("whois.retry.retryable_errors", retryPolicy.RetryableErrors)
+	viper.SetDefault("whois.response.max_bytes", whois.DefaultMaxResponseSize)
+	viper.SetDefault("whois.response.read_timeout_seconds", int(whois.DefaultReadTimeout.Seconds()))
+	viper.SetDefault("email_auth.polling_interval_minutes", 60)
+	viper.SetDefault("takeover.polling_interval_minutes", 60)
+	viper.SetDefault("typosquat.polling_interval_minutes", 1440)
+	viper.SetDefault("typosquat.query_delay_milliseconds", 1000)
+	viper.SetDefault("drop_watch.polling_interval_minutes", 60)
+	viper.SetDefault("network_watch.polling_interval_minutes", 1440)
+	viper.SetDefault("notifications.queue_size", 100)
+	viper.SetDefault("http.listen_address", ApplicationMetricsEndpointPort)
+	viper.SetDefault("shutdown_timeout_seconds", 30)
+	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("logging.format", "logfmt")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
 	err := viper.ReadInConfig()
 	if err != nil {
-		log.Fatal("could not read the configuration file", err)
+		slog.Error("Could not read the configuration file", "error", err)
+		os.Exit(1)
 	}
 	var c configuration
 	err = viper.Unmarshal(&c)
 	if err != nil {
-		log.Fatal("could not unmarshal the configuration file", err)
+		slog.Error("Could not unmarshal the configuration file", "error", err)
+		os.Exit(1)
+	}
+	err = normalizeDomains(&c)
+	if err != nil {
+		slog.Error("Invalid domain in the configuration file", "error", err)
+		os.Exit(1)
+	}
+	err = normalizeNetworks(&c.NetworkWatch)
+	if err != nil {
+		slog.Error("Invalid network in the configuration file", "error", err)
+		os.Exit(1)
+	}
+	err = validatePollingIntervals(c)
+	if err != nil {
+		slog.Error("Invalid polling interval in the configuration file", "error", err)
+		os.Exit(1)
+	}
+	err = validateNotifications(c.Notifications)
+	if err != nil {
+		slog.Error("Invalid notifications in the configuration file", "error", err)
+		os.Exit(1)
 	}
 	return c
 }
+
+// Rejects polling intervals below a minute, which would have the workers
+// poll in a tight loop, whether or not the check is enabled.
+func validatePollingIntervals(c configuration) error {
+	intervals := []struct {
+		key     string
+		minutes int
+	}{
+		{"email_auth.polling_interval_minutes", c.EmailAuth.PollingIntervalMinutes},
+		{"takeover.polling_interval_minutes", c.Takeover.PollingIntervalMinutes},
+		{"typosquat.polling_interval_minutes", c.Typosquat.PollingIntervalMinutes},
+		{"drop_watch.polling_interval_minutes", c.DropWatch.PollingIntervalMinutes},
+		{"network_watch.polling_interval_minutes", c.NetworkWatch.PollingIntervalMinutes},
+	}
+	for _, interval := range intervals {
+		if interval.minutes <= 0 {
+			return fmt.Errorf("invalid %s %d, expected at least 1", interval.key, interval.minutes)
+		}
+	}
+	return nil
+}
+
+// Waits for the duration, returning false early when ctx is done.
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
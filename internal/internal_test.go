@@ -8,3 +8,33 @@ func TestInitConfiguration(t *testing.T) {
 		t.Errorf("expected at least four domains in configuration, found %d", len(appConfig.Domains))
 	}
 }
+
+func TestInitConfigurationWhois(t *testing.T) {
+	appConfig := InitConfiguration()
+	if appConfig.Whois.RootServer != "whois.iana.org" {
+		t.Errorf("expected whois.iana.org as the root server, found %v", appConfig.Whois.RootServer)
+	} else if appConfig.Whois.Dialer.TimeoutSeconds != 10 {
+		t.Errorf("expected a dialer timeout of 10 seconds, found %v", appConfig.Whois.Dialer.TimeoutSeconds)
+	}
+}
+
+func TestValidatePollingIntervals(t *testing.T) {
+	appConfig := InitConfiguration()
+	if err := validatePollingIntervals(appConfig); err != nil {
+		t.Errorf("unexpected error for the defaults %s", err.Error())
+	}
+	var tests = []func(c *configuration){
+		func(c *configuration) { c.EmailAuth.PollingIntervalMinutes = 0 },
+		func(c *configuration) { c.Takeover.PollingIntervalMinutes = 0 },
+		func(c *configuration) { c.Typosquat.PollingIntervalMinutes = -1 },
+		func(c *configuration) { c.DropWatch.PollingIntervalMinutes = 0 },
+		func(c *configuration) { c.NetworkWatch.PollingIntervalMinutes = -5 },
+	}
+	for i, invalidate := range tests {
+		c := InitConfiguration()
+		invalidate(&c)
+		if err := validatePollingIntervals(c); err == nil {
+			t.Errorf("expected an error for invalid polling interval %d", i)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"github.com/giuseppe7/diane/pkg/whois"
+)
+
+// Statuses we expect on production domains unless configured otherwise.
+var defaultRequiredStatuses = []string{"clientTransferProhibited", "clientDeleteProhibited", "clientUpdateProhibited"}
+
+// Group of domains that must carry the same EPP statuses.
+type lockPolicyGroup struct {
+	Name             string   `yaml:"name" mapstructure:"name"`
+	Domains          []string `yaml:"domains" mapstructure:"domains"`
+	RequiredStatuses []string `yaml:"required_statuses" mapstructure:"required_statuses"`
+}
+
+// Outcome of checking a response against one group.
+type lockPolicyResult struct {
+	group   string
+	present []string // Required statuses found on the domain.
+	missing []string // Required statuses not found on the domain.
+}
+
+func (r lockPolicyResult) compliant() bool {
+	return len(r.missing) == 0
+}
+
+type LockPolicy struct {
+	groups []lockPolicyGroup
+}
+
+func NewLockPolicy(groups []lockPolicyGroup) *LockPolicy {
+	policy := new(LockPolicy)
+	for _, group := range groups {
+		if len(group.RequiredStatuses) == 0 {
+			group.RequiredStatuses = defaultRequiredStatuses
+		}
+		policy.groups = append(policy.groups, group)
+	}
+	return policy
+}
+
+// Checks the parsed statuses of the response against every group that lists
+// the domain.
+func (p *LockPolicy) evaluate(domain string, resp whois.Response) []lockPolicyResult {
+	results := []lockPolicyResult{}
+	for _, group := range p.groups {
+		listed := false
+		for _, d := range group.Domains {
+			if whois.SameDomain(d, domain) {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			continue
+		}
+
+		result := lockPolicyResult{group: group.Name}
+		for _, status := range group.RequiredStatuses {
+			if resp.HasStatus(status) {
+				result.present = append(result.present, status)
+			} else {
+				result.missing = append(result.missing, status)
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+)
+
+func TestLockPolicyEvaluate(t *testing.T) {
+	policy := NewLockPolicy([]lockPolicyGroup{
+		{Name: "production", Domains: []string{"Example.com"}},
+		{Name: "transfer-only", Domains: []string{"example.com", "example.net"}, RequiredStatuses: []string{"clientTransferProhibited"}},
+	})
+
+	resp := whois.NewResponse()
+	resp.ParseRawResponse("Domain Name: example.com\nDomain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited\nDomain Status: clientUpdateProhibited https://icann.org/epp#clientUpdateProhibited\n")
+
+	results := policy.evaluate("example.com", resp)
+	if len(results) != 2 {
+		t.Fatalf("expected two groups to apply, found %d", len(results))
+	}
+	if results[0].compliant() || len(results[0].missing) != 1 || results[0].missing[0] != "clientDeleteProhibited" {
+		t.Errorf("expected production to miss clientDeleteProhibited, got %+v", results[0])
+	} else if !results[1].compliant() {
+		t.Errorf("expected transfer-only to be compliant, got %+v", results[1])
+	}
+
+	if results := policy.evaluate("example.org", resp); len(results) != 0 {
+		t.Errorf("expected no groups for an unlisted domain, found %d", len(results))
+	}
+}
@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Settings for the log output of the application.
+type loggingConfiguration struct {
+	Level  string `yaml:"level" mapstructure:"level"`   // debug, info, warn or error.
+	Format string `yaml:"format" mapstructure:"format"` // logfmt or json.
+}
+
+// Builds the logger for the configuration, writing to w. The debug level
+// includes the raw response of every whois server asked.
+func NewLogger(config loggingConfiguration, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(config.Level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", config.Level)
+	}
+	options := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(config.Format) {
+	case "", "logfmt":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", config.Format)
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(loggingConfiguration{Level: "info", Format: "json"}, &buf)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	logger.Debug("Whois hop", "raw", "Domain Name: EXAMPLE.COM")
+	logger.Info("Queried domain", "domain", "example.com", "status", "OK")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a single json entry, got %v", buf.String())
+	}
+	if entry["msg"] != "Queried domain" || entry["domain"] != "example.com" || entry["level"] != "INFO" {
+		t.Errorf("unexpected entry %v", entry)
+	}
+
+	buf.Reset()
+	logger, _ = NewLogger(loggingConfiguration{Level: "DEBUG", Format: "logfmt"}, &buf)
+	logger.Debug("Whois hop", "server", "whois.iana.org")
+	if !strings.Contains(buf.String(), "level=DEBUG msg=\"Whois hop\" server=whois.iana.org") {
+		t.Errorf("expected a logfmt debug entry, got %v", buf.String())
+	}
+
+	var tests = []loggingConfiguration{
+		{Level: "verbose", Format: "json"},
+		{Level: "info", Format: "xml"},
+	}
+	for _, tt := range tests {
+		if _, err := NewLogger(tt, &buf); err == nil {
+			t.Errorf("expected an error for %+v", tt)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Settings for watching the allocation records of our IP prefixes and AS
+// numbers at the regional internet registries.
+type networkWatchConfiguration struct {
+	Enabled                bool     `yaml:"enabled" mapstructure:"enabled"`
+	PollingIntervalMinutes int      `yaml:"polling_interval_minutes" mapstructure:"polling_interval_minutes"`
+	Resources              []string `yaml:"resources" mapstructure:"resources"` // IPs, CIDR prefixes or AS numbers, e.g. AS64496.
+}
+
+// Validates the watched resources and brings them to their canonical form,
+// e.g. 192.0.2.0/24 for 192.0.2.1/24.
+func normalizeNetworks(config *networkWatchConfiguration) error {
+	for i, resource := range config.Resources {
+		kind, canonical := whois.ParseNetwork(resource)
+		if kind == "" {
+			return fmt.Errorf("invalid network %q, expected an IP, a CIDR prefix or an AS number", resource)
+		}
+		config.Resources[i] = canonical
+	}
+	return nil
+}
+
+// What we remember about the allocation record of a watched resource between
+// polls and restarts.
+type networkWatchRecord struct {
+	Server       string    `json:"server"` // Registry that answered last, e.g. whois.ripe.net.
+	Organization string    `json:"organization"`
+	NetName      string    `json:"net_name"`
+	Status       string    `json:"status"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+func newNetworkWatchRecord(resp whois.Response) networkWatchRecord {
+	server, _, err := net.SplitHostPort(resp.HostPort)
+	if err != nil {
+		server = resp.HostPort
+	}
+	return networkWatchRecord{
+		Server:       strings.ToLower(server),
+		Organization: resp.Organization,
+		NetName:      resp.NetName,
+		Status:       resp.NetworkStatus,
+		LastModified: resp.LastModified,
+	}
+}
+
+// Field of a record that differs from the previous one.
+type networkWatchChange struct {
+	name     string
+	previous string
+	current  string
+}
+
+// Fields that differ between the records, in a stable order.
+func (r networkWatchRecord) changes(previous networkWatchRecord) []networkWatchChange {
+	changes := []networkWatchChange{}
+	fields := []networkWatchChange{
+		{"server", previous.Server, r.Server},
+		{"organization", previous.Organization, r.Organization},
+		{"net_name", previous.NetName, r.NetName},
+		{"status", previous.Status, r.Status},
+		{"last_modified", formatLastModified(previous.LastModified), formatLastModified(r.LastModified)},
+	}
+	for _, field := range fields {
+		if field.previous != field.current {
+			changes = append(changes, field)
+		}
+	}
+	return changes
+}
+
+func formatLastModified(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type NetworkWatchWorker struct {
+	query             func(ctx context.Context, target string) whois.Response
+	resources         []string
+	pollingInterval   time.Duration
+	notifier          Notifier
+	store             *StateStore
+	gaugeRecord       *prometheus.GaugeVec
+	gaugeLastModified *prometheus.GaugeVec
+	records           map[string]networkWatchRecord // Record reported by the gauges, per resource.
+}
+
+func NewNetworkWatchWorker(applicationNamespace string, registerer prometheus.Registerer, client *whois.Client, resources []string, pollingInterval time.Duration, notifier Notifier, store *StateStore) *NetworkWatchWorker {
+	worker := new(NetworkWatchWorker)
+	worker.query = client.QueryContext
+	worker.resources = resources
+	worker.pollingInterval = pollingInterval
+	worker.notifier = notifier
+	worker.store = store
+	worker.records = map[string]networkWatchRecord{}
+
+	worker.gaugeRecord = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "network_watch_record",
+			Help:      "Gauge for the current allocation record of a watched IP prefix or AS number, always 1.",
+		},
+		[]string{"resource", "server", "organization", "net_name", "status"},
+	)
+	registerer.MustRegister(worker.gaugeRecord)
+
+	worker.gaugeLastModified = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "network_watch_last_modified_timestamp_seconds",
+			Help:      "Gauge for the last modification of the allocation record of a watched IP prefix or AS number, 0 when unknown.",
+		},
+		[]string{"resource"},
+	)
+	registerer.MustRegister(worker.gaugeLastModified)
+	return worker
+}
+
+// Checks the resources every polling interval until ctx is done.
+func (worker *NetworkWatchWorker) DoWork(ctx context.Context) {
+	for {
+		pollCtx, span := tracer().Start(ctx, "network_watch_worker.poll", trace.WithAttributes(attribute.Int("whois.resources", len(worker.resources))))
+		for _, resource := range worker.resources {
+			resp := worker.query(pollCtx, resource)
+			if ctx.Err() != nil {
+				span.End()
+				return
+			}
+			worker.recordResponse(resource, resp)
+		}
+		span.End()
+		err := worker.store.Flush()
+		if err != nil {
+			slog.Error("Error in flushing state", "error", err)
+		}
+		if !sleepContext(ctx, worker.pollingInterval) {
+			return
+		}
+	}
+}
+
+func networkWatchStateKey(resource string) string {
+	return "networkwatch/" + resource
+}
+
+func (worker *NetworkWatchWorker) recordResponse(resource string, resp whois.Response) {
+	key := networkWatchStateKey(resource)
+	var known networkWatchRecord
+	found, err := worker.store.Get(key, &known)
+	if err != nil {
+		slog.Error("Error in reading state", "key", key, "error", err)
+	}
+
+	if resp.Status == whois.ResponseAvailable {
+		// Returned to the registry or transferred out of its database.
+		if found {
+			worker.notifier.Notify(NewNotification("network_watch", resource, "allocation record is gone", map[string]string{
+				"server":   known.Server,
+				"net_name": known.NetName,
+			}))
+			worker.store.Delete(key)
+			worker.deleteRecord(resource)
+		}
+		slog.Info("Queried watched network", "resource", resource, "server", resp.HostPort, "status", resp.Status.String())
+		return
+	}
+	if resp.Status != whois.ResponseOk || !resp.HasNetwork {
+		// Errors, rate limits and responses without a record tell us nothing,
+		// keep what we know.
+		slog.Info("Queried watched network", "resource", resource, "server", resp.HostPort, "status", resp.Status.String())
+		return
+	}
+
+	record := newNetworkWatchRecord(resp)
+	changes := record.changes(known)
+	if found && len(changes) > 0 {
+		names := []string{}
+		fields := map[string]string{}
+		for _, change := range changes {
+			names = append(names, change.name)
+			fields["previous_"+change.name] = change.previous
+			fields[change.name] = change.current
+		}
+		fields["changed"] = strings.Join(names, ",")
+		worker.notifier.Notify(NewNotification("network_watch", resource, "allocation record changed", fields))
+	}
+	if !found || len(changes) > 0 {
+		err = worker.store.Put(key, record)
+		if err != nil {
+			slog.Error("Error in writing state", "key", key, "error", err)
+		}
+	}
+
+	worker.deleteRecord(resource)
+	worker.records[resource] = record
+	worker.gaugeRecord.WithLabelValues(resource, record.Server, record.Organization, record.NetName, record.Status).Set(1)
+	if resp.HasLastModified {
+		worker.gaugeLastModified.WithLabelValues(resource).Set(float64(resp.LastModified.Unix()))
+	} else {
+		worker.gaugeLastModified.WithLabelValues(resource).Set(0)
+	}
+	slog.Info("Queried watched network", "resource", resource, "server", resp.HostPort, "organization", record.Organization, "net_name", record.NetName, "status", record.Status)
+}
+
+// Drops the series of the record reported so far for the resource.
+func (worker *NetworkWatchWorker) deleteRecord(resource string) {
+	previous, ok := worker.records[resource]
+	if !ok {
+		return
+	}
+	worker.gaugeRecord.DeleteLabelValues(resource, previous.Server, previous.Organization, previous.NetName, previous.Status)
+	worker.gaugeLastModified.DeleteLabelValues(resource)
+	delete(worker.records, resource)
+}
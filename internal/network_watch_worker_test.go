@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const ripeNetworkResponse = "inetnum:        192.0.2.0 - 192.0.2.255\n" +
+	"netname:        EXAMPLE-NET\n" +
+	"org:            ORG-AE1-RIPE\n" +
+	"status:         ALLOCATED PA\n" +
+	"last-modified:  2021-03-22T12:56:44Z\n" +
+	"\n" +
+	"organisation:   ORG-AE1-RIPE\n" +
+	"org-name:       Example B.V.\n"
+
+func newNetworkWatchTestResponse(hostPort string, raw string) whois.Response {
+	resp := whois.ParseResponse(raw)
+	resp.HostPort = hostPort
+	return resp
+}
+
+func TestNormalizeNetworks(t *testing.T) {
+	config := networkWatchConfiguration{Resources: []string{"192.0.2.1/24", "as064496", "2001:DB8::1"}}
+	if err := normalizeNetworks(&config); err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	} else if config.Resources[0] != "192.0.2.0/24" || config.Resources[1] != "AS64496" || config.Resources[2] != "2001:db8::1" {
+		t.Errorf("unexpected canonical forms %v", config.Resources)
+	}
+
+	config = networkWatchConfiguration{Resources: []string{"example.com"}}
+	if err := normalizeNetworks(&config); err == nil {
+		t.Errorf("expected an error for a domain")
+	}
+}
+
+func TestNetworkWatchWorkerRecordResponse(t *testing.T) {
+	notifier := &recordingNotifier{}
+	store, _ := NewStateStore("")
+	worker := NewNetworkWatchWorker(testApplicationNamespace, prometheus.NewRegistry(), whoisClient, []string{"192.0.2.0/24"}, time.Minute, notifier, store)
+
+	worker.recordResponse("192.0.2.0/24", newNetworkWatchTestResponse("whois.ripe.net:43", ripeNetworkResponse))
+	if len(notifier.notifications) != 0 {
+		t.Errorf("expected no notification for the first record seen, found %d", len(notifier.notifications))
+	}
+	if value := testutil.ToFloat64(worker.gaugeRecord.WithLabelValues("192.0.2.0/24", "whois.ripe.net", "Example B.V.", "EXAMPLE-NET", "ALLOCATED PA")); value != 1 {
+		t.Errorf("expected the record to be reported, found %v", value)
+	}
+
+	// Same record, then nothing to say.
+	worker.recordResponse("192.0.2.0/24", newNetworkWatchTestResponse("whois.ripe.net:43", ripeNetworkResponse))
+	worker.recordResponse("192.0.2.0/24", whois.Response{Status: whois.ResponseError})
+	if len(notifier.notifications) != 0 {
+		t.Errorf("expected no notification for an unchanged record or an error, found %+v", notifier.notifications)
+	}
+
+	transferred := "inetnum:        192.0.2.0 - 192.0.2.255\n" +
+		"netname:        OTHER-NET\n" +
+		"descr:          Other Example GmbH\n" +
+		"status:         ASSIGNED PA\n" +
+		"last-modified:  2021-09-01T00:00:00Z\n"
+	worker.recordResponse("192.0.2.0/24", newNetworkWatchTestResponse("whois.ripe.net:43", transferred))
+	if len(notifier.notifications) != 1 {
+		t.Fatalf("expected a notification for the changed record, found %d", len(notifier.notifications))
+	}
+	fields := notifier.notifications[0].Fields
+	if fields["changed"] != "organization,net_name,status,last_modified" {
+		t.Errorf("unexpected changed fields %v", fields["changed"])
+	} else if fields["previous_organization"] != "Example B.V." || fields["organization"] != "Other Example GmbH" {
+		t.Errorf("unexpected organization change %v", fields)
+	}
+	if count := testutil.CollectAndCount(worker.gaugeRecord); count != 1 {
+		t.Errorf("expected only the current record to be reported, found %d series", count)
+	}
+
+	worker.recordResponse("192.0.2.0/24", newNetworkWatchTestResponse("whois.ripe.net:43", "%ERROR:101: no entries found\n"))
+	if len(notifier.notifications) != 2 || notifier.notifications[1].Message != "allocation record is gone" {
+		t.Errorf("expected a notification for the record gone, found %+v", notifier.notifications)
+	} else if found, _ := store.Get(networkWatchStateKey("192.0.2.0/24"), &networkWatchRecord{}); found {
+		t.Errorf("expected the record to be forgotten")
+	}
+	if count := testutil.CollectAndCount(worker.gaugeRecord); count != 0 {
+		t.Errorf("expected no record to be reported, found %d series", count)
+	}
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Something worth telling a human about, e.g. a dangling CNAME record.
+type Notification struct {
+	Kind    string            `json:"kind"`    // Which check raised it, e.g. "takeover".
+	Subject string            `json:"subject"` // What it is about, typically a domain.
+	Message string            `json:"message"` // Human readable summary.
+	Fields  map[string]string `json:"fields,omitempty"`
+	Time    time.Time         `json:"time"`
+}
+
+func NewNotification(kind string, subject string, message string, fields map[string]string) Notification {
+	return Notification{Kind: kind, Subject: subject, Message: message, Fields: fields, Time: time.Now().UTC()}
+}
+
+type Notifier interface {
+	Notify(notification Notification)
+	// Stops accepting notifications and delivers the queued ones, giving up
+	// on them once ctx is done.
+	Close(ctx context.Context)
+}
+
+// Rejects a queue that could not hold a single notification, which would
+// drop every notification raised while one is being posted.
+func validateNotifications(config notificationsConfiguration) error {
+	if config.QueueSize < 1 {
+		return fmt.Errorf("invalid notifications.queue_size %d, expected at least 1", config.QueueSize)
+	}
+	return nil
+}
+
+// Builds the notifier for the configuration, logging only if no webhook is set.
+func NewNotifier(config notificationsConfiguration) Notifier {
+	if config.WebhookURL == "" {
+		return logNotifier{}
+	}
+	return newWebhookNotifier(config.WebhookURL, config.QueueSize)
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Notify(notification Notification) {
+	fields := []any{}
+	for key, value := range notification.Fields {
+		fields = append(fields, key, value)
+	}
+	slog.Warn("Notification", "kind", notification.Kind, "subject", notification.Subject, "message", notification.Message, slog.Group("fields", fields...))
+}
+
+func (logNotifier) Close(ctx context.Context) {}
+
+// Posts notifications as JSON to a webhook from a queue, so a slow endpoint
+// does not hold up the checks raising them.
+type webhookNotifier struct {
+	url        string
+	httpClient *http.Client
+	queue      chan Notification
+	mutex      sync.Mutex
+	closed     bool
+	done       sync.WaitGroup
+	ctx        context.Context // Cancelled to drop whatever is still queued.
+	cancel     context.CancelFunc
+	dropped    int // Queued notifications dropped on close.
+}
+
+func newWebhookNotifier(url string, queueSize int) *webhookNotifier {
+	notifier := new(webhookNotifier)
+	notifier.url = url
+	notifier.httpClient = &http.Client{Timeout: 10 * time.Second}
+	notifier.queue = make(chan Notification, queueSize)
+	notifier.ctx, notifier.cancel = context.WithCancel(context.Background())
+
+	notifier.done.Add(1)
+	go func() {
+		defer notifier.done.Done()
+		for notification := range notifier.queue {
+			if notifier.ctx.Err() != nil {
+				notifier.dropped++
+				continue
+			}
+			err := notifier.post(notification)
+			if err != nil {
+				slog.Error("Error in posting notification", "subject", notification.Subject, "error", err)
+			}
+		}
+	}()
+	return notifier
+}
+
+func (n *webhookNotifier) Notify(notification Notification) {
+	logNotifier{}.Notify(notification)
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- notification:
+	default:
+		slog.Warn("Notification queue is full, dropping", "subject", notification.Subject)
+	}
+}
+
+// Stops accepting notifications and waits for the queue to drain, or for ctx
+// to be done.
+func (n *webhookNotifier) Close(ctx context.Context) {
+	n.mutex.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		n.done.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		// Abort the post in flight and skip the rest.
+		n.cancel()
+		<-drained
+		slog.Warn("Notification queue not drained in time", "dropped", n.dropped)
+	}
+	n.cancel()
+}
+
+func (n *webhookNotifier) post(notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(n.ctx, "POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d from webhook", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewNotifierWithoutWebhook(t *testing.T) {
+	notifier := NewNotifier(notificationsConfiguration{})
+	if _, ok := notifier.(logNotifier); !ok {
+		t.Errorf("expected a log notifier without a webhook url, got %T", notifier)
+	}
+	notifier.Notify(NewNotification("test", "example.com", "just a test", nil))
+	notifier.Close(context.Background())
+}
+
+func TestValidateNotifications(t *testing.T) {
+	if err := validateNotifications(notificationsConfiguration{QueueSize: 1}); err != nil {
+		t.Errorf("unexpected error %s", err.Error())
+	}
+	for _, size := range []int{0, -1} {
+		if err := validateNotifications(notificationsConfiguration{QueueSize: size}); err == nil {
+			t.Errorf("expected an error for a queue size of %d", size)
+		}
+	}
+}
+
+func TestWebhookNotifierDrainsOnClose(t *testing.T) {
+	var mutex sync.Mutex
+	received := []Notification{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification Notification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Errorf("unexpected error decoding notification %s", err.Error())
+		}
+		mutex.Lock()
+		received = append(received, notification)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(notificationsConfiguration{WebhookURL: server.URL, QueueSize: 10})
+	for i := 0; i < 3; i++ {
+		notifier.Notify(NewNotification("test", "example.com", "just a test", map[string]string{"n": "x"}))
+	}
+	notifier.Close(context.Background())
+
+	// Closed notifiers quietly drop anything else.
+	notifier.Notify(NewNotification("test", "example.com", "too late", nil))
+
+	if len(received) != 3 {
+		t.Errorf("expected 3 notifications to be posted, found %d", len(received))
+	} else if received[0].Kind != "test" || received[0].Fields["n"] != "x" {
+		t.Errorf("unexpected notification posted %+v", received[0])
+	}
+}
+
+func TestWebhookNotifierCloseDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	notifier := NewNotifier(notificationsConfiguration{WebhookURL: server.URL, QueueSize: 10})
+	for i := 0; i < 3; i++ {
+		notifier.Notify(NewNotification("test", "example.com", "just a test", nil))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	notifier.Close(ctx)
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected close to give up at the deadline, took %v", time.Since(start))
+	} else if dropped := notifier.(*webhookNotifier).dropped; dropped != 2 {
+		t.Errorf("expected the 2 notifications still queued to be dropped, found %d", dropped)
+	}
+}
+
+// Keeps notifications in memory for tests to inspect.
+type recordingNotifier struct {
+	notifications []Notification
+}
+
+func (r *recordingNotifier) Notify(notification Notification) {
+	r.notifications = append(r.notifications, notification)
+}
+
+func (r *recordingNotifier) Close(ctx context.Context) {}
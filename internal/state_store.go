@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Small key/value store for state that should survive a restart, kept in
+// memory and written to a JSON file on Flush. Without a path nothing is
+// persisted.
+type StateStore struct {
+	path  string
+	mutex sync.Mutex
+	data  map[string]json.RawMessage
+	dirty bool
+}
+
+func NewStateStore(path string) (*StateStore, error) {
+	store := new(StateStore)
+	store.path = path
+	store.data = map[string]json.RawMessage{}
+	if path == "" {
+		return store, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &store.data)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Decodes the value stored under key into value, reporting if it was found.
+func (s *StateStore) Get(key string, value interface{}) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	raw, ok := s.data[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, value)
+}
+
+func (s *StateStore) Put(key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data[key] = raw
+	s.dirty = true
+	return nil
+}
+
+func (s *StateStore) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.data[key]; ok {
+		delete(s.data, key)
+		s.dirty = true
+	}
+}
+
+// Writes any changes to the file, replacing it atomically.
+func (s *StateStore) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), s.path)
+	if err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Reports whether the next Flush can write the file, by creating and removing
+// a file next to it.
+func (s *StateStore) Check() error {
+	if s.path == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".check.*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type stateStoreTestData struct {
+	Name    string
+	Created time.Time
+}
+
+func TestStateStoreRoundTrip(t *testing.T) {
+	dir, err := os.MkdirTemp("", "diane")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	store, err := NewStateStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error creating store %s", err.Error())
+	}
+	expected := stateStoreTestData{Name: "example.com", Created: time.Date(1995, 8, 14, 4, 0, 0, 0, time.UTC)}
+	if err := store.Put("a", expected); err != nil {
+		t.Fatalf("unexpected error in put %s", err.Error())
+	}
+	store.Put("b", "to be deleted")
+	store.Delete("b")
+	if err := store.Flush(); err != nil {
+		t.Fatalf("unexpected error in flush %s", err.Error())
+	}
+
+	reloaded, err := NewStateStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error reloading store %s", err.Error())
+	}
+	var actual stateStoreTestData
+	found, err := reloaded.Get("a", &actual)
+	if err != nil || !found {
+		t.Errorf("expected to find a after reload, found %v error %v", found, err)
+	} else if actual.Name != expected.Name || !actual.Created.Equal(expected.Created) {
+		t.Errorf("reloaded %+v, expected %+v", actual, expected)
+	}
+	if found, _ := reloaded.Get("b", &actual); found {
+		t.Errorf("expected b to stay deleted after reload")
+	}
+}
+
+func TestStateStoreWithoutPath(t *testing.T) {
+	store, err := NewStateStore("")
+	if err != nil {
+		t.Fatalf("unexpected error creating store %s", err.Error())
+	}
+	store.Put("a", 1)
+	if err := store.Flush(); err != nil {
+		t.Errorf("flushing an in-memory store should be a no-op, got %s", err.Error())
+	}
+	var value int
+	if found, _ := store.Get("a", &value); !found || value != 1 {
+		t.Errorf("expected to read back 1, found %v %v", found, value)
+	}
+}
@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Deepest CNAME chain we follow before giving up on a loop.
+const maxCNAMEChain = 10
+
+// Describes what an unclaimed resource of a takeover-prone provider looks like.
+type takeoverFingerprint struct {
+	Provider string   `yaml:"provider" mapstructure:"provider"`
+	CNAMEs   []string `yaml:"cnames" mapstructure:"cnames"`     // Suffixes of CNAME targets hosted by the provider.
+	Body     string   `yaml:"body" mapstructure:"body"`         // Text the provider serves for unclaimed resources.
+	NXDomain bool     `yaml:"nxdomain" mapstructure:"nxdomain"` // Unclaimed resources stop resolving entirely.
+}
+
+// Used when the configuration does not list any fingerprints.
+var defaultTakeoverFingerprints = []takeoverFingerprint{
+	{Provider: "AWS S3", CNAMEs: []string{".s3.amazonaws.com", ".s3-website.amazonaws.com"}, Body: "NoSuchBucket"},
+	{Provider: "GitHub Pages", CNAMEs: []string{".github.io"}, Body: "There isn't a GitHub Pages site here."},
+	{Provider: "Heroku", CNAMEs: []string{".herokuapp.com", ".herokudns.com"}, Body: "No such app"},
+	{Provider: "Azure", CNAMEs: []string{".azurewebsites.net", ".cloudapp.net", ".trafficmanager.net", ".blob.core.windows.net"}, NXDomain: true},
+	{Provider: "Fastly", CNAMEs: []string{".fastly.net"}, Body: "Fastly error: unknown domain"},
+	{Provider: "Shopify", CNAMEs: []string{".myshopify.com"}, Body: "Sorry, this shop is currently unavailable."},
+}
+
+func (f takeoverFingerprint) matches(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, suffix := range f.CNAMEs {
+		if strings.HasSuffix(host, strings.ToLower(strings.TrimSuffix(suffix, "."))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolves one CNAME hop at a time so the whole chain can be inspected.
+type cnameResolver interface {
+	// Returns the CNAME target of name, empty when there is no CNAME record.
+	lookupCNAME(ctx context.Context, name string) (string, error)
+	// Reports whether name resolves to any address.
+	resolves(ctx context.Context, name string) (bool, error)
+}
+
+type dnsCNAMEResolver struct {
+	client     *dns.Client
+	nameserver string
+}
+
+// Builds a resolver against the nameserver, or the first one in
+// /etc/resolv.conf when empty.
+func newDNSCNAMEResolver(nameserver string) (*dnsCNAMEResolver, error) {
+	if nameserver == "" {
+		config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+		if len(config.Servers) == 0 {
+			return nil, errors.New("no nameservers found in /etc/resolv.conf")
+		}
+		nameserver = net.JoinHostPort(config.Servers[0], config.Port)
+	}
+	resolver := new(dnsCNAMEResolver)
+	resolver.client = &dns.Client{Timeout: 10 * time.Second}
+	resolver.nameserver = nameserver
+	return resolver, nil
+}
+
+func (r *dnsCNAMEResolver) lookupCNAME(ctx context.Context, name string) (string, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeCNAME)
+	in, _, err := r.client.ExchangeContext(ctx, msg, r.nameserver)
+	if err != nil {
+		return "", err
+	}
+	if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+		return "", fmt.Errorf("cname lookup of %s failed with %s", name, dns.RcodeToString[in.Rcode])
+	}
+	for _, answer := range in.Answer {
+		if cname, ok := answer.(*dns.CNAME); ok {
+			return strings.TrimSuffix(cname.Target, "."), nil
+		}
+	}
+	return "", nil
+}
+
+// Asks the nameserver for A and then AAAA records, so the answer comes from
+// the same server as the CNAME chain rather than the system resolver.
+func (r *dnsCNAMEResolver) resolves(ctx context.Context, name string) (bool, error) {
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(name), qtype)
+		in, _, err := r.client.ExchangeContext(ctx, msg, r.nameserver)
+		if err != nil {
+			return false, err
+		}
+		if in.Rcode == dns.RcodeNameError {
+			return false, nil
+		}
+		if in.Rcode != dns.RcodeSuccess {
+			return false, fmt.Errorf("%s lookup of %s failed with %s", dns.TypeToString[qtype], name, dns.RcodeToString[in.Rcode])
+		}
+		for _, answer := range in.Answer {
+			switch answer.(type) {
+			case *dns.A, *dns.AAAA:
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Result of checking a single subdomain.
+type TakeoverFinding struct {
+	subdomain  string
+	chain      []string // CNAME targets in order, the last one is the final target.
+	dangling   bool     // Final target does not resolve.
+	provider   string   // Provider of a matching fingerprint.
+	vulnerable bool     // Fingerprint of an unclaimed resource matched.
+	err        error    // Error exception caught while checking.
+}
+
+// Reports whether the finding needs someone to look at it.
+func (f TakeoverFinding) flagged() bool {
+	return f.dangling || f.vulnerable
+}
+
+func (f TakeoverFinding) reason() string {
+	if f.vulnerable {
+		return fmt.Sprintf("points at an unclaimed %s resource", f.provider)
+	} else if f.dangling {
+		return "cname target no longer resolves"
+	}
+	return "ok"
+}
+
+type TakeoverChecker struct {
+	resolver     cnameResolver
+	fingerprints []takeoverFingerprint
+	fetchBody    func(ctx context.Context, host string) (string, error)
+}
+
+func NewTakeoverChecker(nameserver string, fingerprints []takeoverFingerprint) (*TakeoverChecker, error) {
+	resolver, err := newDNSCNAMEResolver(nameserver)
+	if err != nil {
+		return nil, err
+	}
+	checker := new(TakeoverChecker)
+	checker.resolver = resolver
+	checker.fingerprints = fingerprints
+	if len(checker.fingerprints) == 0 {
+		checker.fingerprints = defaultTakeoverFingerprints
+	}
+	checker.fetchBody = fetchHTTPBody
+	return checker, nil
+}
+
+func fetchHTTPBody(ctx context.Context, host string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+host+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// Follows the CNAME chain of the subdomain and checks the targets against
+// the fingerprints.
+func (c *TakeoverChecker) Check(ctx context.Context, subdomain string) TakeoverFinding {
+	finding := TakeoverFinding{subdomain: subdomain}
+
+	name := dnsName(subdomain)
+	seen := map[string]bool{strings.ToLower(name): true}
+	for len(finding.chain) < maxCNAMEChain {
+		target, err := c.resolver.lookupCNAME(ctx, name)
+		if err != nil {
+			finding.err = err
+			return finding
+		}
+		if target == "" {
+			break
+		}
+		finding.chain = append(finding.chain, target)
+		if seen[strings.ToLower(target)] {
+			finding.err = fmt.Errorf("cname loop detected at %s", target)
+			return finding
+		}
+		seen[strings.ToLower(target)] = true
+		name = target
+	}
+	if len(finding.chain) == 0 {
+		// Not an alias, nothing to take over.
+		return finding
+	}
+
+	resolves, err := c.resolver.resolves(ctx, name)
+	if err != nil {
+		finding.err = err
+		return finding
+	}
+	finding.dangling = !resolves
+
+	for _, fingerprint := range c.fingerprints {
+		matched := false
+		for _, target := range finding.chain {
+			if fingerprint.matches(target) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		finding.provider = fingerprint.Provider
+		if fingerprint.NXDomain && finding.dangling {
+			finding.vulnerable = true
+		} else if fingerprint.Body != "" && resolves {
+			body, err := c.fetchBody(ctx, subdomain)
+			if err != nil {
+				finding.err = err
+			} else if strings.Contains(body, fingerprint.Body) {
+				finding.vulnerable = true
+			}
+		}
+		break
+	}
+	return finding
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// In-memory CNAME resolver, names missing from hosts do not resolve.
+type fakeCNAMEResolver struct {
+	cnames map[string]string
+	hosts  map[string]bool
+}
+
+func (f fakeCNAMEResolver) lookupCNAME(ctx context.Context, name string) (string, error) {
+	return f.cnames[name], nil
+}
+
+func (f fakeCNAMEResolver) resolves(ctx context.Context, name string) (bool, error) {
+	return f.hosts[name], nil
+}
+
+func newTestTakeoverChecker(resolver fakeCNAMEResolver, body string) *TakeoverChecker {
+	checker := new(TakeoverChecker)
+	checker.resolver = resolver
+	checker.fingerprints = defaultTakeoverFingerprints
+	checker.fetchBody = func(ctx context.Context, host string) (string, error) {
+		return body, nil
+	}
+	return checker
+}
+
+func TestTakeoverCheckerCheck(t *testing.T) {
+	resolver := fakeCNAMEResolver{
+		cnames: map[string]string{
+			"www.example.com":              "example.com.edgesuite.net",
+			"old.example.com":              "gone.example.net",
+			"docs.example.com":             "docs-example.cdn.example.net",
+			"docs-example.cdn.example.net": "example.github.io",
+			"app.example.com":              "example-app.azurewebsites.net",
+			"static.example.com":           "static-example.s3.amazonaws.com",
+		},
+		hosts: map[string]bool{
+			"apex.example.com":                true,
+			"example.com.edgesuite.net":       true,
+			"example.github.io":               true,
+			"static-example.s3.amazonaws.com": true,
+		},
+	}
+	checker := newTestTakeoverChecker(resolver, "<h1>404</h1> There isn't a GitHub Pages site here.")
+
+	var tests = []struct {
+		subdomain  string
+		chain      int
+		dangling   bool
+		vulnerable bool
+		provider   string
+	}{
+		{subdomain: "apex.example.com", chain: 0},
+		{subdomain: "www.example.com", chain: 1},
+		{subdomain: "old.example.com", chain: 1, dangling: true},
+		{subdomain: "docs.example.com", chain: 2, vulnerable: true, provider: "GitHub Pages"},
+		{subdomain: "app.example.com", chain: 1, dangling: true, vulnerable: true, provider: "Azure"},
+		{subdomain: "static.example.com", chain: 1, vulnerable: false, provider: "AWS S3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subdomain, func(t *testing.T) {
+			finding := checker.Check(context.Background(), tt.subdomain)
+			if finding.err != nil {
+				t.Errorf("Check(%s) error %s", tt.subdomain, finding.err.Error())
+			} else if len(finding.chain) != tt.chain {
+				t.Errorf("Check(%s) chain was %v, expected %d hops", tt.subdomain, finding.chain, tt.chain)
+			} else if finding.dangling != tt.dangling {
+				t.Errorf("Check(%s) dangling was %v, expected %v", tt.subdomain, finding.dangling, tt.dangling)
+			} else if finding.vulnerable != tt.vulnerable {
+				t.Errorf("Check(%s) vulnerable was %v, expected %v", tt.subdomain, finding.vulnerable, tt.vulnerable)
+			} else if finding.provider != tt.provider {
+				t.Errorf("Check(%s) provider was %v, expected %v", tt.subdomain, finding.provider, tt.provider)
+			}
+		})
+	}
+}
+
+func TestTakeoverCheckerLoop(t *testing.T) {
+	resolver := fakeCNAMEResolver{
+		cnames: map[string]string{
+			"a.example.com": "b.example.com",
+			"b.example.com": "a.example.com",
+		},
+	}
+	checker := newTestTakeoverChecker(resolver, "")
+
+	finding := checker.Check(context.Background(), "a.example.com")
+	if finding.err == nil {
+		t.Errorf("expected a cname loop to be reported as an error")
+	}
+}
+
+func TestDNSCNAMEResolverResolves(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		question := r.Question[0]
+		switch {
+		case question.Name == "v4.example.com." && question.Qtype == dns.TypeA:
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("192.0.2.1")})
+		case question.Name == "v6.example.com." && question.Qtype == dns.TypeAAAA:
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60}, AAAA: net.ParseIP("2001:db8::1")})
+		case question.Name == "broken.example.com.":
+			msg.Rcode = dns.RcodeServerFailure
+		case question.Name == "gone.example.com.":
+			msg.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(msg)
+	})
+	server := &dns.Server{PacketConn: conn, Handler: mux}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	resolver, err := newDNSCNAMEResolver(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	var tests = []struct {
+		name     string
+		expected bool
+		err      bool
+	}{
+		{name: "v4.example.com", expected: true},
+		{name: "v6.example.com", expected: true},
+		{name: "empty.example.com", expected: false},
+		{name: "gone.example.com", expected: false},
+		{name: "broken.example.com", err: true},
+	}
+	for _, test := range tests {
+		resolves, err := resolver.resolves(context.Background(), test.name)
+		if test.err {
+			if err == nil {
+				t.Errorf("resolves(%v) expected an error", test.name)
+			}
+		} else if err != nil {
+			t.Errorf("resolves(%v) unexpected error %s", test.name, err.Error())
+		} else if resolves != test.expected {
+			t.Errorf("resolves(%v) was %v, expected %v", test.name, resolves, test.expected)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type TakeoverWorker struct {
+	checker         *TakeoverChecker
+	subdomains      []string
+	pollingInterval time.Duration
+	notifier        Notifier
+	gaugeFlagged    *prometheus.GaugeVec
+	gaugeChain      *prometheus.GaugeVec
+	last            map[string]TakeoverFinding // Last finding per subdomain.
+}
+
+func NewTakeoverWorker(applicationNamespace string, registerer prometheus.Registerer, checker *TakeoverChecker, subdomains []string, pollingInterval time.Duration, notifier Notifier) *TakeoverWorker {
+	worker := new(TakeoverWorker)
+	worker.checker = checker
+	worker.subdomains = subdomains
+	worker.pollingInterval = pollingInterval
+	worker.notifier = notifier
+	worker.last = map[string]TakeoverFinding{}
+
+	worker.gaugeFlagged = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "takeover_subdomain_flagged",
+			Help:      "Gauge for subdomains with a dangling or takeover-prone CNAME, 1 when flagged.",
+		},
+		[]string{"subdomain", "reason"},
+	)
+	registerer.MustRegister(worker.gaugeFlagged)
+
+	worker.gaugeChain = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "takeover_cname_chain_length",
+			Help:      "Gauge for length of the CNAME chain of a subdomain.",
+		},
+		[]string{"subdomain"},
+	)
+	registerer.MustRegister(worker.gaugeChain)
+	return worker
+}
+
+// Checks the subdomains every polling interval until ctx is done.
+func (worker *TakeoverWorker) DoWork(ctx context.Context) {
+	for {
+		for _, subdomain := range worker.subdomains {
+			checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			finding := worker.checker.Check(checkCtx, subdomain)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			worker.recordFinding(finding)
+		}
+		if !sleepContext(ctx, worker.pollingInterval) {
+			return
+		}
+	}
+}
+
+func (worker *TakeoverWorker) recordFinding(finding TakeoverFinding) {
+	if finding.err != nil {
+		// Keep the previous state rather than flapping on a lookup failure.
+		slog.Warn("Error in takeover check", "domain", finding.subdomain, "error", finding.err)
+		return
+	}
+
+	subdomain := finding.subdomain
+	flagged := finding.flagged()
+	last, seen := worker.last[subdomain]
+	if seen && last.reason() != finding.reason() {
+		worker.gaugeFlagged.DeleteLabelValues(subdomain, last.reason())
+	}
+	worker.gaugeFlagged.WithLabelValues(subdomain, finding.reason()).Set(boolToFloat(flagged))
+	worker.gaugeChain.WithLabelValues(subdomain).Set(float64(len(finding.chain)))
+
+	if flagged && !last.flagged() {
+		worker.notifier.Notify(NewNotification("takeover", subdomain, finding.reason(), map[string]string{
+			"chain":    strings.Join(finding.chain, " -> "),
+			"provider": finding.provider,
+		}))
+	} else if !flagged && last.flagged() {
+		worker.notifier.Notify(NewNotification("takeover", subdomain, "no longer flagged", nil))
+	}
+	worker.last[subdomain] = finding
+	slog.Info("Checked for takeover", "domain", subdomain, "status", finding.reason())
+}
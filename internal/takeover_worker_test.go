@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTakeoverWorkerRecordFinding(t *testing.T) {
+	notifier := &recordingNotifier{}
+	worker := NewTakeoverWorker(testApplicationNamespace, prometheus.NewRegistry(), nil, []string{"old.example.com"}, time.Minute, notifier)
+
+	finding := TakeoverFinding{subdomain: "old.example.com", chain: []string{"gone.example.net"}, dangling: true}
+	worker.recordFinding(finding)
+	worker.recordFinding(finding)
+
+	if len(notifier.notifications) != 1 {
+		t.Errorf("expected a single notification while flagged, found %d", len(notifier.notifications))
+	} else if value := testutil.ToFloat64(worker.gaugeFlagged.WithLabelValues("old.example.com", finding.reason())); value != 1 {
+		t.Errorf("expected the subdomain to be flagged, got %v", value)
+	}
+
+	worker.recordFinding(TakeoverFinding{subdomain: "old.example.com"})
+	if len(notifier.notifications) != 2 {
+		t.Errorf("expected a notification when no longer flagged, found %d", len(notifier.notifications))
+	} else if count := testutil.CollectAndCount(worker.gaugeFlagged); count != 1 {
+		t.Errorf("expected the previous reason to be removed, found %d series", count)
+	}
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitTracing(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := InitTracing(context.Background(), tracingConfiguration{Exporter: "stdout", File: file, SampleRatio: 1}, "test")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	_, span := tracer().Start(context.Background(), "whois_worker.poll")
+	span.End()
+	err = shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	spans, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	} else if !strings.Contains(string(spans), `"Name":"whois_worker.poll"`) || !strings.Contains(string(spans), `"Value":"diane"`) {
+		t.Errorf("expected the span to be exported to the file, got %v", string(spans))
+	}
+
+	_, err = InitTracing(context.Background(), tracingConfiguration{Exporter: "zipkin"}, "test")
+	if err == nil {
+		t.Errorf("expected an error for an unknown exporter")
+	}
+	shutdown, err = InitTracing(context.Background(), tracingConfiguration{}, "test")
+	if err != nil || shutdown(context.Background()) != nil {
+		t.Errorf("expected tracing to be off without an exporter, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+)
+
+// Permutation rules for generating lookalike domains.
+const (
+	PermutationOmission      = "omission"      // examle.com
+	PermutationTransposition = "transposition" // exmaple.com
+	PermutationHomoglyph     = "homoglyph"     // examp1e.com
+	PermutationTLD           = "tld"           // example.net
+	PermutationHyphenation   = "hyphenation"   // exa-mple.com
+)
+
+var allPermutationRules = []string{
+	PermutationOmission,
+	PermutationTransposition,
+	PermutationHomoglyph,
+	PermutationTLD,
+	PermutationHyphenation,
+}
+
+// ASCII lookalikes, both directions are tried.
+var homoglyphs = map[string][]string{
+	"o":  {"0"},
+	"0":  {"o"},
+	"l":  {"1", "i"},
+	"i":  {"1", "l"},
+	"1":  {"l", "i"},
+	"m":  {"rn"},
+	"rn": {"m"},
+	"w":  {"vv"},
+	"vv": {"w"},
+	"d":  {"cl"},
+	"cl": {"d"},
+	"e":  {"3"},
+	"a":  {"4"},
+	"s":  {"5"},
+}
+
+// Letters with diacritics and the ASCII they pass for, one way only so ASCII
+// domains do not grow internationalised lookalikes.
+var diacriticHomoglyphs = map[string][]string{
+	"ä": {"a", "ae"},
+	"ö": {"o", "oe"},
+	"ü": {"u", "ue"},
+	"ß": {"ss"},
+	"à": {"a"},
+	"á": {"a"},
+	"é": {"e"},
+	"è": {"e"},
+	"ç": {"c"},
+	"ñ": {"n"},
+}
+
+// Splits a domain into the label to permute and the suffix after it,
+// e.g. "example" and "co.uk".
+func splitDomain(domain string) (string, string) {
+	parts := strings.SplitN(strings.ToLower(domain), ".", 2)
+	if len(parts) != 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// Rejects leading and trailing hyphens and hyphens in the third and fourth
+// place, which are reserved for encodings such as the "xn--" of punycode.
+func isValidLabel(label string) bool {
+	runes := []rune(label)
+	return len(runes) > 0 && runes[0] != '-' && runes[len(runes)-1] != '-' && !(len(runes) >= 4 && runes[2] == '-' && runes[3] == '-')
+}
+
+// Generates the lookalikes of the domain for the rules, all rules when empty.
+// TLD swaps use the given TLDs. Internationalised labels are permuted letter
+// by letter in Unicode and the lookalikes returned in punycode, dropping the
+// ones IDNA refuses. The result is sorted and excludes the domain.
+func generatePermutations(domain string, rules []string, tlds []string) []string {
+	if len(rules) == 0 {
+		rules = allPermutationRules
+	}
+	label, suffix := splitDomain(whois.ToUnicode(domain))
+	letters := []rune(label)
+
+	labels := map[string]bool{}
+	candidates := map[string]bool{}
+	addCandidate := func(candidate string) {
+		ascii, err := whois.ToASCII(candidate)
+		if err == nil {
+			candidates[ascii] = true
+		}
+	}
+	for _, rule := range rules {
+		switch strings.ToLower(rule) {
+		case PermutationOmission:
+			for i := range letters {
+				labels[string(letters[:i])+string(letters[i+1:])] = true
+			}
+		case PermutationTransposition:
+			for i := 0; i < len(letters)-1; i++ {
+				swapped := append([]rune{}, letters...)
+				swapped[i], swapped[i+1] = swapped[i+1], swapped[i]
+				labels[string(swapped)] = true
+			}
+		case PermutationHomoglyph:
+			for _, lookalikes := range []map[string][]string{homoglyphs, diacriticHomoglyphs} {
+				for original, replacements := range lookalikes {
+					for i := 0; i+len(original) <= len(label); i++ {
+						if label[i:i+len(original)] != original {
+							continue
+						}
+						for _, replacement := range replacements {
+							labels[label[:i]+replacement+label[i+len(original):]] = true
+						}
+					}
+				}
+			}
+		case PermutationTLD:
+			for _, tld := range tlds {
+				addCandidate(label + "." + strings.TrimPrefix(strings.ToLower(tld), "."))
+			}
+		case PermutationHyphenation:
+			for i := 1; i < len(letters); i++ {
+				labels[string(letters[:i])+"-"+string(letters[i:])] = true
+			}
+		}
+	}
+	for permuted := range labels {
+		if !isValidLabel(permuted) {
+			continue
+		}
+		if suffix == "" {
+			addCandidate(permuted)
+		} else {
+			addCandidate(permuted + "." + suffix)
+		}
+	}
+	if ascii, err := whois.ToASCII(domain); err == nil {
+		delete(candidates, ascii)
+	}
+
+	result := make([]string, 0, len(candidates))
+	for candidate := range candidates {
+		result = append(result, candidate)
+	}
+	sort.Strings(result)
+	return result
+}
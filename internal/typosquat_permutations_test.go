@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+)
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGeneratePermutations(t *testing.T) {
+	var tests = []struct {
+		rule     string
+		expected []string
+	}{
+		{rule: PermutationOmission, expected: []string{"xample.com", "examle.com", "exampl.com"}},
+		{rule: PermutationTransposition, expected: []string{"xeample.com", "exmaple.com", "exampel.com"}},
+		{rule: PermutationHomoglyph, expected: []string{"examp1e.com", "exampie.com", "exarnple.com", "3xample.com"}},
+		{rule: PermutationTLD, expected: []string{"example.net", "example.org"}},
+		{rule: PermutationHyphenation, expected: []string{"e-xample.com", "exam-ple.com", "exampl-e.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			permutations := generatePermutations("example.com", []string{tt.rule}, []string{"net", ".org", "com"})
+			for _, expected := range tt.expected {
+				if !containsString(permutations, expected) {
+					t.Errorf("generatePermutations(%s) missing %v in %v", tt.rule, expected, permutations)
+				}
+			}
+			if containsString(permutations, "example.com") {
+				t.Errorf("generatePermutations(%s) should not include the domain itself", tt.rule)
+			}
+		})
+	}
+}
+
+func TestGeneratePermutationsAllRules(t *testing.T) {
+	all := generatePermutations("example.co.uk", nil, []string{"com"})
+	if !containsString(all, "exampl.co.uk") || !containsString(all, "example.com") {
+		t.Errorf("expected all rules to apply when none are configured, got %v", all)
+	}
+	for _, permutation := range all {
+		if !isValidLabel(splitDomainLabel(permutation)) {
+			t.Errorf("invalid permutation %v", permutation)
+		}
+	}
+}
+
+func splitDomainLabel(domain string) string {
+	label, _ := splitDomain(domain)
+	return label
+}
+
+func TestGeneratePermutationsInternationalised(t *testing.T) {
+	expected := []string{
+		"xn--mler-0ra.de",   // müler, omission of an l
+		"mller.de",          // omission of the ü
+		"xn--mller-lva.de",  // lü swapped, mlüler
+		"xn--m1ler-kva.de",  // homoglyph of l
+		"muller.de",         // ü without its diaeresis
+		"mueller.de",        // ü spelled out
+		"xn--mller-kva.com", // tld swap
+		"xn--m-ller-3ya.de", // hyphenation, mü-ller
+	}
+	for _, domain := range []string{"müller.de", "xn--mller-kva.de"} {
+		permutations := generatePermutations(domain, nil, []string{"com"})
+		for _, lookalike := range expected {
+			if !containsString(permutations, lookalike) {
+				t.Errorf("generatePermutations(%s) missing %v in %v", domain, lookalike, permutations)
+			}
+		}
+		for _, permutation := range permutations {
+			if permutation == "xn--mller-kva.de" {
+				t.Errorf("generatePermutations(%s) should not include the domain itself", domain)
+			} else if _, err := whois.ToASCII(permutation); err != nil {
+				t.Errorf("generatePermutations(%s) returned invalid %v", domain, permutation)
+			}
+		}
+	}
+}
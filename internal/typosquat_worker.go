@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Settings for watching the lookalikes of one of our domains.
+type typosquatDomainConfiguration struct {
+	Domain string   `yaml:"domain" mapstructure:"domain"`
+	Rules  []string `yaml:"rules" mapstructure:"rules"` // Permutation rules, all of them when empty.
+	TLDs   []string `yaml:"tlds" mapstructure:"tlds"`   // TLDs to swap in for the tld rule.
+	Allow  []string `yaml:"allow" mapstructure:"allow"` // Lookalikes we own or do not care about.
+}
+
+// What we remember about a registered lookalike between polls and restarts.
+type typosquatRegistration struct {
+	Registrar string    `json:"registrar"`
+	Creation  time.Time `json:"creation"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+type TyposquatWorker struct {
+	query           func(ctx context.Context, target string) whois.Response
+	domains         []typosquatDomainConfiguration
+	pollingInterval time.Duration
+	queryDelay      time.Duration
+	notifier        Notifier
+	store           *StateStore
+	gaugeCandidates *prometheus.GaugeVec
+	gaugeRegistered *prometheus.GaugeVec
+}
+
+func NewTyposquatWorker(applicationNamespace string, registerer prometheus.Registerer, client *whois.Client, domains []typosquatDomainConfiguration, pollingInterval time.Duration, queryDelay time.Duration, notifier Notifier, store *StateStore) *TyposquatWorker {
+	worker := new(TyposquatWorker)
+	worker.query = client.QueryContext
+	worker.domains = domains
+	worker.pollingInterval = pollingInterval
+	worker.queryDelay = queryDelay
+	worker.notifier = notifier
+	worker.store = store
+
+	worker.gaugeCandidates = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "typosquat_candidates",
+			Help:      "Gauge for number of lookalike domains checked for a domain.",
+		},
+		[]string{"domain"},
+	)
+	registerer.MustRegister(worker.gaugeCandidates)
+
+	worker.gaugeRegistered = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "typosquat_lookalike_registered",
+			Help:      "Gauge for registered lookalikes of a domain, 1 while registered.",
+		},
+		[]string{"domain", "lookalike", "registrar"},
+	)
+	registerer.MustRegister(worker.gaugeRegistered)
+	return worker
+}
+
+// Checks the domains every polling interval until ctx is done.
+func (worker *TyposquatWorker) DoWork(ctx context.Context) {
+	for {
+		pollCtx, span := tracer().Start(ctx, "typosquat_worker.poll", trace.WithAttributes(attribute.Int("typosquat.domains", len(worker.domains))))
+		for _, domain := range worker.domains {
+			worker.checkDomain(pollCtx, domain)
+		}
+		span.End()
+		err := worker.store.Flush()
+		if err != nil {
+			slog.Error("Error in flushing state", "error", err)
+		}
+		if !sleepContext(ctx, worker.pollingInterval) {
+			return
+		}
+	}
+}
+
+func typosquatStateKey(lookalike string) string {
+	return "typosquat/" + lookalike
+}
+
+// Marks a lookalike the registry last said is available, holding the time it
+// was first seen so. Domains hold no slash so this never clashes with a
+// registered lookalike.
+func typosquatAvailableKey(lookalike string) string {
+	return "typosquat/available/" + lookalike
+}
+
+func (worker *TyposquatWorker) checkDomain(ctx context.Context, config typosquatDomainConfiguration) {
+	allowed := map[string]bool{}
+	for _, allow := range config.Allow {
+		allowed[strings.ToLower(allow)] = true
+	}
+
+	candidates := generatePermutations(config.Domain, config.Rules, config.TLDs)
+	worker.gaugeCandidates.WithLabelValues(config.Domain).Set(float64(len(candidates)))
+	for i, candidate := range candidates {
+		if allowed[candidate] {
+			continue
+		}
+		if i > 0 && !sleepContext(ctx, worker.queryDelay) {
+			return
+		}
+		resp := worker.query(ctx, candidate)
+		if ctx.Err() != nil {
+			return
+		}
+		worker.recordResponse(config.Domain, candidate, resp)
+	}
+}
+
+// Records the response for the lookalike, notifying when it turns up
+// registered after the registry said it was available. The first definitive
+// answer about a lookalike only records it, so the ones registered long ago
+// are not reported. Without a state path that is repeated on every start.
+func (worker *TyposquatWorker) recordResponse(domain string, lookalike string, resp whois.Response) {
+	key := typosquatStateKey(lookalike)
+	var known typosquatRegistration
+	wasRegistered, err := worker.store.Get(key, &known)
+	if err != nil {
+		slog.Error("Error in reading state", "key", key, "error", err)
+	}
+	availableKey := typosquatAvailableKey(lookalike)
+	var availableSince time.Time
+	wasAvailable, err := worker.store.Get(availableKey, &availableSince)
+	if err != nil {
+		slog.Error("Error in reading state", "key", availableKey, "error", err)
+	}
+
+	switch resp.Status {
+	case whois.ResponseOk:
+		registration := typosquatRegistration{Registrar: resp.Registrar, Creation: resp.Creation, FirstSeen: time.Now().UTC()}
+		if wasRegistered {
+			registration.FirstSeen = known.FirstSeen
+			if known.Registrar != resp.Registrar {
+				worker.gaugeRegistered.DeleteLabelValues(domain, lookalike, known.Registrar)
+			}
+		} else if wasAvailable {
+			fields := map[string]string{"lookalike_of": domain, "registrar": resp.Registrar}
+			if resp.HasCreation {
+				fields["created"] = resp.Creation.Format("2006-01-02")
+			}
+			worker.notifier.Notify(NewNotification("typosquat", lookalike, "lookalike domain is registered", fields))
+		}
+		worker.gaugeRegistered.WithLabelValues(domain, lookalike, resp.Registrar).Set(1)
+		err = worker.store.Put(key, registration)
+		if err != nil {
+			slog.Error("Error in writing state", "key", key, "error", err)
+		}
+		worker.store.Delete(availableKey)
+	case whois.ResponseAvailable:
+		if wasRegistered {
+			worker.gaugeRegistered.DeleteLabelValues(domain, lookalike, known.Registrar)
+			worker.store.Delete(key)
+		}
+		if !wasAvailable {
+			err = worker.store.Put(availableKey, time.Now().UTC())
+			if err != nil {
+				slog.Error("Error in writing state", "key", availableKey, "error", err)
+			}
+		}
+	default:
+		// Errors and rate limits tell us nothing, keep what we know.
+		slog.Info("Queried lookalike", "domain", lookalike, "lookalike_of", domain, "server", resp.HostPort, "status", resp.Status.String())
+	}
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTyposquatWorkerCheckDomain(t *testing.T) {
+	notifier := &recordingNotifier{}
+	store, _ := NewStateStore("")
+	worker := NewTyposquatWorker(testApplicationNamespace, prometheus.NewRegistry(), whoisClient, nil, time.Minute, 0, notifier, store)
+
+	registered := map[string]bool{"examp1e.com": true, "exampel.com": true, "exarnple.com": true}
+	limited := map[string]bool{"exarnple.com": true}
+	worker.query = func(ctx context.Context, target string) whois.Response {
+		resp := whois.NewResponse()
+		resp.Target = target
+		if limited[target] {
+			resp.Status = whois.ResponseExceededRate
+		} else if registered[target] {
+			resp.ParseRawResponse("Domain Name: " + target + "\nRegistrar: Example Registrar, Inc.\nCreation Date: 2021-06-01T00:00:00Z\n")
+		} else {
+			resp.ParseRawResponse("No match for \"" + target + "\".\n")
+		}
+		return resp
+	}
+
+	config := typosquatDomainConfiguration{
+		Domain: "example.com",
+		Rules:  []string{PermutationHomoglyph, PermutationTransposition},
+		Allow:  []string{"exampel.com"},
+	}
+	// The first pass records the lookalikes registered already without a word.
+	worker.checkDomain(context.Background(), config)
+	if len(notifier.notifications) != 0 {
+		t.Fatalf("expected no notification for the baseline, found %+v", notifier.notifications)
+	}
+	if value := testutil.ToFloat64(worker.gaugeRegistered.WithLabelValues("example.com", "examp1e.com", "Example Registrar, Inc.")); value != 1 {
+		t.Errorf("expected examp1e.com to be registered, got %v", value)
+	}
+
+	// A lookalike we got no answer for on the first pass is no news once the
+	// registry answers, it may have been registered all along.
+	delete(limited, "exarnple.com")
+	registered["exapmle.com"] = true
+	worker.checkDomain(context.Background(), config)
+	worker.checkDomain(context.Background(), config)
+
+	if len(notifier.notifications) != 1 {
+		t.Fatalf("expected one notification for the newly registered lookalike, found %d", len(notifier.notifications))
+	}
+	notification := notifier.notifications[0]
+	if notification.Subject != "exapmle.com" {
+		t.Errorf("expected a notification for exapmle.com, got %v", notification.Subject)
+	} else if notification.Fields["registrar"] != "Example Registrar, Inc." || notification.Fields["created"] != "2021-06-01" {
+		t.Errorf("unexpected notification fields %v", notification.Fields)
+	}
+
+	// Dropped lookalikes are forgotten.
+	delete(registered, "examp1e.com")
+	worker.checkDomain(context.Background(), config)
+	if found, _ := store.Get(typosquatStateKey("examp1e.com"), &typosquatRegistration{}); found {
+		t.Errorf("expected examp1e.com to be removed from state once available")
+	}
+	registered["examp1e.com"] = true
+	worker.checkDomain(context.Background(), config)
+	if len(notifier.notifications) != 2 || notifier.notifications[1].Subject != "examp1e.com" {
+		t.Errorf("expected a notification for examp1e.com registered again, found %+v", notifier.notifications)
+	}
+}
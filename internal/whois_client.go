@@ -1,102 +1,252 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"time"
 
+	"github.com/giuseppe7/diane/pkg/whois"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/proxy"
+	"golang.org/x/text/encoding"
 )
 
-type WhoisClient struct {
-	histogram *prometheus.HistogramVec
-	counter   *prometheus.CounterVec
+// Exports the queries of a whois client to Prometheus.
+type whoisClientMetrics struct {
+	lowCardinality bool // Labels queries by TLD instead of target.
+	histogram      *prometheus.HistogramVec
+	counter        *prometheus.CounterVec
+	dialHistogram  *prometheus.HistogramVec
+	readHistogram  *prometheus.HistogramVec
+	bytesCounter   *prometheus.CounterVec
+	retryCounter   *prometheus.CounterVec
 }
 
-func NewWhoisClient(applicationNamespace string) *WhoisClient {
-	whoisClient := new(WhoisClient)
+// Buckets of the whois latency histograms in seconds.
+var whoisDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30}
+
+func newWhoisClientMetrics(applicationNamespace string, registerer prometheus.Registerer, lowCardinality bool) *whoisClientMetrics {
+	metrics := new(whoisClientMetrics)
+	metrics.lowCardinality = lowCardinality
+
+	// A series per target gets expensive with thousands of domains.
+	labels := []string{"target", "refer", "status"}
+	if lowCardinality {
+		labels = []string{"tld", "server", "status"}
+	}
 
 	// Capture metrics on the command execution times.
-	whoisClient.histogram = prometheus.NewHistogramVec(
+	metrics.histogram = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: applicationNamespace,
 			Name:      "whois_client_command_duration_seconds",
 			Help:      "Histogram of client calls in seconds.",
-			Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30},
+			Buckets:   whoisDurationBuckets,
 		},
-		[]string{"target", "refer", "status"},
+		labels,
 	)
-	prometheus.MustRegister(whoisClient.histogram)
+	registerer.MustRegister(metrics.histogram)
 
-	whoisClient.counter = prometheus.NewCounterVec(
+	metrics.counter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: applicationNamespace,
 			Name:      "whois_client_command_status",
 			Help:      "Counter for status of client calls.",
 		},
-		[]string{"target", "refer", "status"},
+		labels,
+	)
+	registerer.MustRegister(metrics.counter)
+
+	metrics.dialHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_client_dial_duration_seconds",
+			Help:      "Histogram of connecting to a whois server in seconds.",
+			Buckets:   whoisDurationBuckets,
+		},
+		[]string{"server"},
 	)
-	prometheus.MustRegister(whoisClient.counter)
+	registerer.MustRegister(metrics.dialHistogram)
 
-	return whoisClient
+	metrics.readHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_client_read_duration_seconds",
+			Help:      "Histogram of reading the response of a whois server in seconds.",
+			Buckets:   whoisDurationBuckets,
+		},
+		[]string{"server"},
+	)
+	registerer.MustRegister(metrics.readHistogram)
+
+	metrics.bytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_client_received_bytes_total",
+			Help:      "Counter for bytes received from a whois server.",
+		},
+		[]string{"server"},
+	)
+	registerer.MustRegister(metrics.bytesCounter)
+
+	metrics.retryCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_client_retries_total",
+			Help:      "Counter for whois servers asked again, by the error class or status retried.",
+		},
+		[]string{"server", "reason"},
+	)
+	registerer.MustRegister(metrics.retryCounter)
+	return metrics
 }
 
-// Performs the whois query via port 43 protocol and returns a simplied single
-// response intentionally because I'm a jerk and this is not meant to be
-// exhaustive.
-func (w *WhoisClient) Query(target string) WhoisResponse {
-	hostPort := "whois.iana.org:43" // Default search before referrals.
-
-	referral := "whois.iana.org" // No referral, then its iana.
-	start := time.Now()
-	whoisResponse := w.sendRequest(hostPort, target)
-	if whoisResponse.err == nil {
-		// No issues, check if a referral is sent.
-		if whoisResponse.refer != "" {
-			// Referral found, second invocation.
-			referral = whoisResponse.refer
-			referHostPort := fmt.Sprintf("%s:43", whoisResponse.refer)
-			whoisResponse = w.sendRequest(referHostPort, target)
+func (m *whoisClientMetrics) ObserveQuery(target string, server string, status whois.ResponseType, duration time.Duration) {
+	first := target
+	if m.lowCardinality {
+		// IPs, prefixes and AS numbers have no TLD, label them by kind.
+		first, _ = whois.ParseNetwork(target)
+		if first == "" {
+			first = whois.TLD(target)
 		}
 	}
-	duration := time.Since(start)
-	w.histogram.WithLabelValues(target, referral, whoisResponse.status.String()).Observe(duration.Seconds())
-	w.counter.WithLabelValues(target, referral, whoisResponse.status.String()).Inc()
+	m.histogram.WithLabelValues(first, server, status.String()).Observe(duration.Seconds())
+	m.counter.WithLabelValues(first, server, status.String()).Inc()
+}
 
-	return whoisResponse
+func (m *whoisClientMetrics) ObserveHop(server string, dial time.Duration, read time.Duration, bytes int) {
+	m.dialHistogram.WithLabelValues(server).Observe(dial.Seconds())
+	if read > 0 || bytes > 0 {
+		m.readHistogram.WithLabelValues(server).Observe(read.Seconds())
+		m.bytesCounter.WithLabelValues(server).Add(float64(bytes))
+	}
 }
 
-func (w *WhoisClient) sendRequest(hostPort string, target string) WhoisResponse {
-	var resp WhoisResponse
-	resp.target = target
-	resp.hostPort = hostPort
+// Implements whois.RetryObserver, which the client looks for on its metrics.
+func (m *whoisClientMetrics) ObserveRetry(server string, reason string) {
+	m.retryCounter.WithLabelValues(server, reason).Inc()
+}
 
-	d := net.Dialer{Timeout: 10 * time.Second}
-	conn, err := d.Dial("tcp", hostPort) // Typically host:43
-	if err != nil {
-		resp.status = ResponseError
-		resp.err = err
-		return resp
-	}
-	defer conn.Close()
-
-	conn.Write([]byte(target + "\r\n"))
-	buf := make([]byte, 1024)
-	result := []byte{}
-	for {
-		numBytes, err := conn.Read(buf)
-		sbuf := buf[0:numBytes]
-		result = append(result, sbuf...)
+// Builds the whois client of the daemon, exporting its queries to Prometheus
+// through the metrics registered with registerer. The low cardinality mode
+// labels queries by TLD and server rather than by target.
+func NewWhoisClient(applicationNamespace string, registerer prometheus.Registerer, lowCardinality bool, options ...whois.Option) *whois.Client {
+	options = append([]whois.Option{whois.WithMetrics(newWhoisClientMetrics(applicationNamespace, registerer, lowCardinality))}, options...)
+	return whois.NewClient(options...)
+}
+
+// Builds the client options from the whois section of the configuration.
+func WhoisClientOptions(config whoisConfiguration) ([]whois.Option, error) {
+	tldServers := map[string]string{}
+	for _, override := range config.Servers {
+		tldServers[override.TLD] = override.Server
+	}
+	serverPorts := map[string]int{}
+	for _, override := range config.Ports {
+		serverPorts[override.Server] = override.Port
+	}
+	serverEncodings := map[string]encoding.Encoding{}
+	for _, override := range config.Encodings {
+		enc, err := whois.LookupEncoding(override.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		serverEncodings[override.Server] = enc
+	}
+	queryTemplates := map[string]string{}
+	for _, override := range config.Templates {
+		err := whois.CheckQueryTemplate(override.Template)
 		if err != nil {
-			break
+			return nil, err
 		}
+		queryTemplates[override.Server] = override.Template
+	}
+	options := []whois.Option{
+		whois.WithRootServer(config.RootServer),
+		whois.WithTLDServers(tldServers),
+		whois.WithServerPorts(serverPorts),
+		whois.WithServerEncodings(serverEncodings),
+		whois.WithQueryTemplates(queryTemplates),
+		whois.WithMaxResponseSize(config.Response.MaxBytes),
+		whois.WithReadTimeout(time.Duration(config.Response.ReadTimeoutSeconds) * time.Second),
+	}
+
+	dialer, err := newConfiguredDialer(config.Dialer)
+	if err != nil {
+		return nil, err
 	}
+	retryPolicy, err := newRetryPolicy(config.Retry)
 	if err != nil {
-		resp.status = ResponseError
-		resp.err = err
-		return resp
+		return nil, err
+	}
+	return append(options, whois.WithDialer(dialer), whois.WithRetryPolicy(retryPolicy)), nil
+}
+
+// Error classes the retry policy accepts.
+var retryableErrorClasses = map[string]bool{
+	whois.ErrorTimeout:           true,
+	whois.ErrorConnectionReset:   true,
+	whois.ErrorConnectionRefused: true,
+	whois.ErrorDNS:               true,
+	whois.ErrorOther:             true,
+}
+
+// Builds the retry policy, refusing error classes and statuses it cannot
+// retry.
+func newRetryPolicy(config whoisRetryConfiguration) (whois.RetryPolicy, error) {
+	policy := whois.RetryPolicy{
+		MaxAttempts: config.MaxAttempts,
+		Backoff:     time.Duration(config.BackoffMilliseconds) * time.Millisecond,
+		MaxBackoff:  time.Duration(config.MaxBackoffMilliseconds) * time.Millisecond,
+	}
+	for _, class := range config.RetryableErrors {
+		if !retryableErrorClasses[class] {
+			return policy, fmt.Errorf("invalid retryable error %q", class)
+		}
+		policy.RetryableErrors = append(policy.RetryableErrors, class)
+	}
+	for _, name := range config.RetryableStatuses {
+		status, err := whois.ParseResponseType(name)
+		if err != nil {
+			return policy, err
+		}
+		if status == whois.ResponseExceededRate {
+			return policy, fmt.Errorf("invalid retryable status %q, rate limits are never retried", name)
+		} else if status == whois.ResponseError {
+			return policy, fmt.Errorf("invalid retryable status %q, list the error classes in retryable errors", name)
+		}
+		policy.RetryableStatuses = append(policy.RetryableStatuses, status)
+	}
+	return policy, nil
+}
+
+// Builds a dialer bound to the source address and going through the SOCKS5
+// proxy when configured.
+func newConfiguredDialer(config dialerConfiguration) (whois.Dialer, error) {
+	netDialer := &net.Dialer{Timeout: time.Duration(config.TimeoutSeconds) * time.Second}
+	if config.SourceAddress != "" {
+		ip := net.ParseIP(config.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %q", config.SourceAddress)
+		}
+		netDialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if config.SOCKS5Proxy == "" {
+		return netDialer, nil
 	}
 
-	resp.ParseRawResponse(string(result))
-	return resp
+	var auth *proxy.Auth
+	if config.SOCKS5Username != "" {
+		auth = &proxy.Auth{User: config.SOCKS5Username, Password: config.SOCKS5Password}
+	}
+	socksDialer, err := proxy.SOCKS5("tcp", config.SOCKS5Proxy, auth, netDialer)
+	if err != nil {
+		return nil, err
+	}
+	contextDialer, ok := socksDialer.(whois.Dialer)
+	if !ok {
+		return nil, errors.New("socks5 dialer does not support contexts")
+	}
+	return contextDialer, nil
 }
@@ -1,175 +1,267 @@
 package internal
 
 import (
+	"net"
+	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+	"github.com/giuseppe7/diane/pkg/whois/whoistest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 const testApplicationNamespace = "test_diane"
 
-var whois = NewWhoisClient(testApplicationNamespace)
+// Fake whois servers with canned responses for the configured domains, so the
+// worker tests run offline.
+var whoisServer *whoistest.Server
+var whoisClient *whois.Client
+
+const ianaExampleResponse = `% IANA WHOIS server
+% for more information on IANA, visit http://www.iana.org
+% This query returned 1 object
+
+domain:       EXAMPLE.COM
+
+organisation: Internet Assigned Numbers Authority
+
+created:      1992-01-01
+source:       IANA
+
+`
 
-type whoisTestData struct {
-	target        string
-	domain        string
-	hasExpiration bool
-	expiration    time.Time
+const verisignGithubResponse = `   Domain Name: GITHUB.COM
+   Registry Domain ID: 1264983250_DOMAIN_COM-VRSN
+   Registrar WHOIS Server: whois.markmonitor.com
+   Registrar URL: http://www.markmonitor.com
+   Updated Date: 2020-09-08T09:18:27Z
+   Creation Date: 2007-10-09T18:20:50Z
+   Registry Expiry Date: 2022-10-09T18:20:50Z
+   Registrar: MarkMonitor Inc.
+   Registrar IANA ID: 292
+   Domain Status: clientDeleteProhibited https://icann.org/epp#clientDeleteProhibited
+   Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited
+   Domain Status: clientUpdateProhibited https://icann.org/epp#clientUpdateProhibited
+   Name Server: DNS1.P08.NSONE.NET
+   DNSSEC: unsigned
+>>> Last update of whois database: 2021-09-01T00:00:00Z <<<
+`
+
+const verisignGitlabResponse = `   Domain Name: GITLAB.COM
+   Registry Domain ID: 1773782961_DOMAIN_COM-VRSN
+   Registrar WHOIS Server: whois.gandi.net
+   Updated Date: 2020-12-11T10:04:07Z
+   Creation Date: 2013-01-15T20:29:55Z
+   Registry Expiry Date: 2025-01-15T20:29:55Z
+   Registrar: Gandi SAS
+   Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited
+>>> Last update of whois database: 2021-09-01T00:00:00Z <<<
+`
+
+const pirExampleResponse = `Domain Name: EXAMPLE.ORG
+Registry Domain ID: D2328855-LROR
+Updated Date: 2021-07-01T00:00:00Z
+Creation Date: 1995-08-31T04:00:00Z
+Registry Expiry Date: 2010-08-30T04:00:00Z
+Registrar Registration Expiration Date:
+Registrar: Internet Assigned Numbers Authority
+Domain Status: serverDeleteProhibited https://icann.org/epp#serverDeleteProhibited
+`
+
+func newTestWhoisServer() *whoistest.Server {
+	server := whoistest.NewServer()
+	server.Host("whois.iana.org").
+		Respond("example.com", whoistest.Text(ianaExampleResponse)).
+		Respond("example.net", whoistest.Text(strings.Replace(ianaExampleResponse, "EXAMPLE.COM", "EXAMPLE.NET", 1))).
+		Respond("example.org", whoistest.Referral("whois.pir.org")).
+		Default(whoistest.Referral("whois.verisign-grs.com"))
+	server.Host("whois.verisign-grs.com").
+		Respond("=github.com", whoistest.Text(verisignGithubResponse)).
+		Respond("=gitlab.com", whoistest.Trickle(verisignGitlabResponse, 64, time.Millisecond))
+	server.Host("whois.pir.org").Respond("example.org", whoistest.Text(pirExampleResponse))
+	server.Host("whois.nic.es").Default(whoistest.Text("IP address used to perform the query not authorised for whois access.\r\n"))
+	return server
 }
 
-func TestWhoisClientAvailable(t *testing.T) {
+func TestMain(m *testing.M) {
+	whoisServer = newTestWhoisServer()
+	whoisClient = NewWhoisClient(testApplicationNamespace, prometheus.NewRegistry(), false, whois.WithDialer(whoisServer))
 
-	var tests = []whoisTestData{
-		{target: "somethingmadeup123.com"},
-	}
-	for _, tt := range tests {
-		t.Run(tt.target, func(t *testing.T) {
-			resp := whois.Query(tt.target)
-
-			if resp.err != nil {
-				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.err.Error())
-			} else if resp.raw == "" {
-				t.Errorf("whoisQuery(%s) expected non empty raw response.", tt.target)
-			} else if resp.target == "" {
-				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
-			} else if resp.hostPort == "" {
-				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.hostPort)
-			} else if resp.status != ResponseAvailable {
-				t.Errorf("whoisQuery(%s) expected to be available.", tt.target)
-			}
-		})
+	code := m.Run()
+	whoisServer.Close()
+	os.Exit(code)
+}
+
+func TestNewWhoisClientMetrics(t *testing.T) {
+	metrics := newWhoisClientMetrics(testApplicationNamespace, prometheus.NewRegistry(), false)
+	client := whois.NewClient(whois.WithDialer(whoisServer), whois.WithMetrics(metrics))
+	client.Query("github.com")
+
+	if count := testutil.ToFloat64(metrics.counter.WithLabelValues("github.com", "whois.verisign-grs.com", "OK")); count != 1 {
+		t.Errorf("expected one OK query of github.com, found %v", count)
+	} else if count := testutil.CollectAndCount(metrics.histogram); count != 1 {
+		t.Errorf("expected one histogram series, found %d", count)
 	}
 }
 
-func TestWhoisClientNotAvailable(t *testing.T) {
+func TestWhoisClientMetricsLowCardinality(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := newWhoisClientMetrics(testApplicationNamespace, registry, true)
+	client := whois.NewClient(whois.WithDialer(whoisServer), whois.WithMetrics(metrics))
+	client.Query("github.com")
+	client.Query("gitlab.com")
 
-	var tests = []whoisTestData{
-		{target: "example.com"},
+	if count := testutil.ToFloat64(metrics.counter.WithLabelValues("com", "whois.verisign-grs.com", "OK")); count != 2 {
+		t.Errorf("expected both queries under the com TLD, found %v", count)
+	} else if count := testutil.CollectAndCount(metrics.counter); count != 1 {
+		t.Errorf("expected a single series without targets, found %d", count)
 	}
-	for _, tt := range tests {
-		t.Run(tt.target, func(t *testing.T) {
-			resp := whois.Query(tt.target)
-
-			if resp.err != nil {
-				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.err.Error())
-			} else if resp.raw == "" {
-				t.Errorf("whoisQuery(%s) expected non empty raw response.", tt.target)
-			} else if resp.target == "" {
-				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
-			} else if resp.status == ResponseAvailable {
-				t.Errorf("whoisQuery(%s) expected to be not available. %+v", tt.target, resp.raw)
-			} else if resp.hostPort == "" {
-				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.hostPort)
-			}
-		})
+	if count := testutil.CollectAndCount(metrics.dialHistogram); count != 2 {
+		t.Errorf("expected dial latency for the root and com servers, found %d", count)
+	} else if bytes := testutil.ToFloat64(metrics.bytesCounter.WithLabelValues("whois.verisign-grs.com")); bytes != float64(len(verisignGithubResponse)+len(verisignGitlabResponse)) {
+		t.Errorf("unexpected bytes received %v", bytes)
+	}
+
+	metrics.ObserveQuery("192.0.2.0/24", "whois.ripe.net", whois.ResponseOk, time.Second)
+	metrics.ObserveQuery("AS64496", "whois.arin.net", whois.ResponseOk, time.Second)
+	if count := testutil.ToFloat64(metrics.counter.WithLabelValues("cidr", "whois.ripe.net", "OK")); count != 1 {
+		t.Errorf("expected the prefix under its kind, found %v", count)
+	} else if count := testutil.ToFloat64(metrics.counter.WithLabelValues("asn", "whois.arin.net", "OK")); count != 1 {
+		t.Errorf("expected the AS number under its kind, found %v", count)
 	}
 }
 
-func TestWhoisClientExampleNoRefer(t *testing.T) {
+func TestWhoisClientOptions(t *testing.T) {
+	config := whoisConfiguration{
+		RootServer: "whois.example-root.net",
+		Servers:    []whoisServerConfiguration{{TLD: "es", Server: "whois.nic.es"}},
+		Ports:      []whoisPortConfiguration{{Server: "whois.nic.es", Port: 4343}},
+		Dialer:     dialerConfiguration{TimeoutSeconds: 5, SourceAddress: "127.0.0.1"},
+	}
+	options, err := WhoisClientOptions(config)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	client := whois.NewClient(append(options, whois.WithDialer(whoisServer))...)
+	resp := client.Query("example.es")
+	if resp.HostPort != "whois.nic.es:4343" {
+		t.Errorf("expected the es override on port 4343, got %v", resp.HostPort)
+	} else if resp.Status != whois.ResponseUnauthorized {
+		t.Errorf("whois.Query(example.es) status was %v, expected %v", resp.Status, whois.ResponseUnauthorized)
+	}
 
-	var tests = []whoisTestData{
-		{target: "example.com", domain: "example.com"},
-		{target: "example.net", domain: "example.net"},
+	dialer, err := newConfiguredDialer(config.Dialer)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	} else if netDialer, ok := dialer.(*net.Dialer); !ok || netDialer.LocalAddr.String() != "127.0.0.1:0" {
+		t.Errorf("expected a dialer bound to 127.0.0.1, got %+v", dialer)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.target, func(t *testing.T) {
-			resp := whois.Query(tt.target)
-
-			if resp.err != nil {
-				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.err.Error())
-			} else if resp.hostPort == "" {
-				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.hostPort)
-			} else if resp.target == "" {
-				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
-			} else if resp.domain != tt.domain {
-				t.Errorf("whois.Query(%s) got domain %v, expected %v", tt.target, resp.domain, tt.domain)
-			}
-		})
+	config.Encodings = []whoisEncodingConfiguration{{Server: "whois.kr", Encoding: "euc-kr"}}
+	if _, err := WhoisClientOptions(config); err != nil {
+		t.Errorf("unexpected error with an encoding %s", err.Error())
 	}
-}
+	config.Encodings[0].Encoding = "klingon"
+	if _, err := WhoisClientOptions(config); err == nil {
+		t.Errorf("expected an error for an unknown encoding")
+	}
+	config.Encodings = nil
 
-func TestWhoisClientExampleWithRefer(t *testing.T) {
+	config.Templates = []whoisTemplateConfiguration{{Server: "whois.nic.es", Template: "domain {query}"}}
+	options, err = WhoisClientOptions(config)
+	if err != nil {
+		t.Fatalf("unexpected error with a query template %s", err.Error())
+	}
+	client = whois.NewClient(append(options, whois.WithDialer(whoisServer))...)
+	client.Query("example.es")
+	if queries := whoisServer.Host("whois.nic.es").Queries(); queries[len(queries)-1] != "domain example.es" {
+		t.Errorf("expected the es server to be asked with its template, got %v", queries)
+	}
+	config.Templates[0].Template = "domain example.es"
+	if _, err := WhoisClientOptions(config); err == nil {
+		t.Errorf("expected an error for a query template without placeholder")
+	}
+	config.Templates = nil
 
-	var tests = []whoisTestData{
-		{target: "example.edu", domain: "example.edu"},
-		{target: "example.org", domain: "example.org"},
+	config.Dialer.SOCKS5Proxy = "127.0.0.1:1080"
+	if _, err := WhoisClientOptions(config); err != nil {
+		t.Errorf("unexpected error with a socks5 proxy %s", err.Error())
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.domain, func(t *testing.T) {
-			resp := whois.Query(tt.domain)
-
-			if resp.err != nil {
-				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.err.Error())
-			} else if resp.hostPort == "" {
-				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.hostPort)
-			} else if resp.target == "" {
-				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
-			} else if resp.domain != tt.domain {
-				t.Errorf("whois.Query(%s) got domain %v, expected %v", tt.target, resp.domain, tt.domain)
-			}
-		})
+	config.Dialer.SourceAddress = "not-an-ip"
+	if _, err := WhoisClientOptions(config); err == nil {
+		t.Errorf("expected an error for an invalid source address")
 	}
 }
 
-func TestWhoisClientForExpirations(t *testing.T) {
-
-	var tests = []whoisTestData{
-		{target: "example.com", domain: "example.com", hasExpiration: false},
-		{target: "example.net", domain: "example.net", hasExpiration: false},
-		{target: "example.edu", domain: "example.edu", hasExpiration: true, expiration: time.Date(2023, 7, 31, 0, 0, 0, 0, time.UTC)},
-		{target: "example.org", domain: "example.org", hasExpiration: true, expiration: time.Date(2010, 8, 30, 0, 0, 0, 0, time.UTC)},
-		{target: "github.com", domain: "github.com", hasExpiration: true, expiration: time.Date(2022, 10, 9, 0, 0, 0, 0, time.UTC)},
-		{target: "gitlab.com", domain: "gitlab.com", hasExpiration: true, expiration: time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)},
+func TestNewRetryPolicy(t *testing.T) {
+	policy, err := newRetryPolicy(whoisRetryConfiguration{
+		MaxAttempts:            3,
+		BackoffMilliseconds:    250,
+		MaxBackoffMilliseconds: 2000,
+		RetryableErrors:        []string{"timeout", "connection_reset"},
+		RetryableStatuses:      []string{"Unauthorized"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	} else if policy.MaxAttempts != 3 || policy.Backoff != 250*time.Millisecond || policy.MaxBackoff != 2*time.Second {
+		t.Errorf("unexpected policy %+v", policy)
+	} else if len(policy.RetryableStatuses) != 1 || policy.RetryableStatuses[0] != whois.ResponseUnauthorized {
+		t.Errorf("unexpected retryable statuses %v", policy.RetryableStatuses)
 	}
 
+	var tests = []whoisRetryConfiguration{
+		{RetryableErrors: []string{"flaky"}},
+		{RetryableStatuses: []string{"Busy"}},
+		{RetryableStatuses: []string{"ExceededRate"}},
+		{RetryableStatuses: []string{"Error"}},
+	}
 	for _, tt := range tests {
-		t.Run(tt.target, func(t *testing.T) {
-			resp := whois.Query(tt.target)
-
-			if resp.err != nil {
-				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.err.Error())
-			} else if resp.hostPort == "" {
-				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.hostPort)
-			} else if resp.target == "" {
-				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
-			} else if resp.domain != tt.domain {
-				t.Errorf("whois.Query(%s) domain was %v, expected %v", tt.target, resp.domain, tt.domain)
-			} else if resp.hasExpiration != tt.hasExpiration {
-				t.Errorf("whois.Query(%s) hasExpiration was %v, expected %v", tt.target, resp.hasExpiration, tt.hasExpiration)
-			} else if resp.hasExpiration {
-				// TODO: Check year, month, day only for now.
-				if resp.expiration.Year() != tt.expiration.Year() ||
-					resp.expiration.Month() != tt.expiration.Month() ||
-					resp.expiration.Day() != tt.expiration.Day() {
-					t.Errorf("whois.Query(%s) expiration was %v, expected %v", tt.target, resp.expiration.Local(), tt.expiration.Local())
-				}
+		if _, err := newRetryPolicy(tt); err == nil {
+			t.Errorf("expected an error for %+v", tt)
+		}
+	}
+}
+
+func TestWhoisClientRetriesMetric(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	client := NewWhoisClient(testApplicationNamespace, registry, false,
+		whois.WithDialer(whoisServer),
+		whois.WithTLDServers(map[string]string{"xyz": "whois.nic.xyz"}),
+		whois.WithRetryPolicy(whois.RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond, RetryableErrors: []string{whois.ErrorDNS}}),
+	)
+	client.Query("unknownhost.xyz")
 
+	metrics, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	retries := 0.0
+	for _, family := range metrics {
+		if family.GetName() == testApplicationNamespace+"_whois_client_retries_total" {
+			for _, metric := range family.GetMetric() {
+				retries += metric.GetCounter().GetValue()
 			}
-		})
+		}
+	}
+	if retries != 1 {
+		t.Errorf("expected one retry to be counted, found %v", retries)
 	}
 }
 
-func TestWhoisClientForNotAuthorized(t *testing.T) {
-	// Apparently .es uses an unconventional whois server.
-	// https://en.wikipedia.org/wiki/.es
-	var tests = []whoisTestData{
-		{target: "example.es", domain: "example.es"},
-	}
+func TestNewWhoisClientRegisterer(t *testing.T) {
+	// Clients on their own registries no longer clash over the same names.
+	first := prometheus.NewRegistry()
+	second := prometheus.NewRegistry()
+	NewWhoisClient(ApplicationNamespace, first, false, whois.WithDialer(whoisServer)).Query("github.com")
+	NewWhoisClient(ApplicationNamespace, second, false, whois.WithDialer(whoisServer))
 
-	for _, tt := range tests {
-		t.Run(tt.target, func(t *testing.T) {
-			resp := whois.Query(tt.target)
-
-			if resp.err != nil {
-				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.err.Error())
-			} else if resp.hostPort == "" {
-				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.hostPort)
-			} else if resp.target == "" {
-				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
-			} else if resp.status != ResponseUnauthorized {
-				t.Errorf("whois.Query(%s) expected to return unauthorized.", tt.target)
-			}
-		})
+	if count, err := testutil.GatherAndCount(first, "diane_whois_client_command_status"); err != nil || count != 1 {
+		t.Errorf("expected one series on the first registry, found %d error %v", count, err)
+	} else if count, err := testutil.GatherAndCount(second, "diane_whois_client_command_status"); err != nil || count != 0 {
+		t.Errorf("expected no series on the second registry, found %d error %v", count, err)
 	}
 }
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"bufio"
+	_ "embed"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//go:embed data/whois_servers.txt
+var bundledWhoisServers string
+
+// Key the cached referrals are kept under in the state store.
+const referralCacheStateKey = "whois/referrals"
+
+type referralCacheEntry struct {
+	Server  string    `json:"server"`
+	Expires time.Time `json:"expires"`
+}
+
+// Remembers which whois server the root server referred each TLD to, so it
+// only has to be asked again once the entry expires.
+type ReferralCache struct {
+	ttl     time.Duration
+	store   *StateStore
+	mutex   sync.Mutex
+	entries map[string]referralCacheEntry
+	counter *prometheus.CounterVec
+}
+
+func NewReferralCache(applicationNamespace string, registerer prometheus.Registerer, ttl time.Duration, store *StateStore, prepopulate bool) (*ReferralCache, error) {
+	cache := new(ReferralCache)
+	cache.ttl = ttl
+	cache.store = store
+	cache.entries = map[string]referralCacheEntry{}
+
+	if prepopulate {
+		expires := time.Now().Add(ttl)
+		for tld, server := range parseWhoisServers(bundledWhoisServers) {
+			cache.entries[tld] = referralCacheEntry{Server: server, Expires: expires}
+		}
+	}
+	persisted := map[string]referralCacheEntry{}
+	_, err := store.Get(referralCacheStateKey, &persisted)
+	if err != nil {
+		return nil, err
+	}
+	for tld, entry := range persisted {
+		cache.entries[tld] = entry
+	}
+
+	cache.counter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_client_referral_cache_total",
+			Help:      "Counter for referral cache lookups by result, hit or miss.",
+		},
+		[]string{"result"},
+	)
+	registerer.MustRegister(cache.counter)
+	return cache, nil
+}
+
+// Parses "<tld> <server>" lines, skipping blanks and comments.
+func parseWhoisServers(text string) map[string]string {
+	servers := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			slog.Warn("Skipping malformed whois server line", "line", line)
+			continue
+		}
+		servers[strings.ToLower(fields[0])] = fields[1]
+	}
+	return servers
+}
+
+// Returns the cached server for the TLD unless missing or expired.
+func (c *ReferralCache) Get(tld string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[tld]
+	if !ok || time.Now().After(entry.Expires) {
+		c.counter.WithLabelValues("miss").Inc()
+		return "", false
+	}
+	c.counter.WithLabelValues("hit").Inc()
+	return entry.Server, true
+}
+
+func (c *ReferralCache) Put(tld string, server string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[tld] = referralCacheEntry{Server: server, Expires: time.Now().Add(c.ttl)}
+	c.persist()
+}
+
+// Forgets the TLD, e.g. when its cached server stopped answering.
+func (c *ReferralCache) Delete(tld string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, tld)
+	c.persist()
+}
+
+// Hands the entries to the state store, which writes them on its next flush.
+func (c *ReferralCache) persist() {
+	err := c.store.Put(referralCacheStateKey, c.entries)
+	if err != nil {
+		slog.Error("Error in writing state", "key", referralCacheStateKey, "error", err)
+	}
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+	"github.com/giuseppe7/diane/pkg/whois/whoistest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseWhoisServers(t *testing.T) {
+	servers := parseWhoisServers(bundledWhoisServers)
+	if servers["com"] != "whois.verisign-grs.com" {
+		t.Errorf("expected com in the bundled list, found %v", servers["com"])
+	} else if len(servers) < 40 {
+		t.Errorf("expected a decent bundled list, found %d entries", len(servers))
+	}
+
+	servers = parseWhoisServers("# comment\n\nES whois.nic.es\nbroken line here\n")
+	if len(servers) != 1 || servers["es"] != "whois.nic.es" {
+		t.Errorf("unexpected servers %v", servers)
+	}
+}
+
+func TestReferralCache(t *testing.T) {
+	store, _ := NewStateStore("")
+	store.Put(referralCacheStateKey, map[string]referralCacheEntry{
+		"com": {Server: "whois.example.com", Expires: time.Now().Add(time.Hour)},
+		"old": {Server: "whois.nic.old", Expires: time.Now().Add(-time.Hour)},
+	})
+	cache, err := NewReferralCache(testApplicationNamespace, prometheus.NewRegistry(), time.Hour, store, true)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+
+	if server, ok := cache.Get("com"); !ok || server != "whois.example.com" {
+		t.Errorf("expected persisted entries to win over bundled ones, got %v", server)
+	} else if server, ok := cache.Get("de"); !ok || server != "whois.denic.de" {
+		t.Errorf("expected a bundled entry for de, got %v", server)
+	} else if _, ok := cache.Get("old"); ok {
+		t.Errorf("expected expired entries to miss")
+	}
+	if hits := testutil.ToFloat64(cache.counter.WithLabelValues("hit")); hits != 2 {
+		t.Errorf("expected 2 hits, found %v", hits)
+	} else if misses := testutil.ToFloat64(cache.counter.WithLabelValues("miss")); misses != 1 {
+		t.Errorf("expected 1 miss, found %v", misses)
+	}
+}
+
+func TestWhoisClientWithReferralCache(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	iana := server.Host("whois.iana.org").Default(whoistest.Referral("whois.verisign-grs.com"))
+	server.Host("whois.verisign-grs.com").Respond("=github.com", whoistest.Text(verisignGithubResponse))
+
+	store, _ := NewStateStore("")
+	cache, _ := NewReferralCache(testApplicationNamespace, prometheus.NewRegistry(), time.Hour, store, false)
+	client := whois.NewClient(whois.WithDialer(server), whois.WithReferralCache(cache))
+
+	for i := 0; i < 3; i++ {
+		resp := client.Query("github.com")
+		if resp.Domain != "github.com" {
+			t.Errorf("whois.Query(github.com) domain was %v", resp.Domain)
+		}
+	}
+	if queries := iana.Queries(); len(queries) != 1 {
+		t.Errorf("expected the root server to be asked once, found %v", queries)
+	}
+
+	// Cached servers that stop answering are forgotten.
+	cache.Put("org", "whois.gone.example")
+	client.Query("example.org")
+	if _, ok := cache.Get("org"); ok {
+		t.Errorf("expected the failing org referral to be dropped from the cache")
+	}
+}
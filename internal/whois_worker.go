@@ -1,24 +1,47 @@
 package internal
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"math"
+	"strings"
 	"time"
 
+	"github.com/giuseppe7/diane/pkg/whois"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WhoisWorker struct {
-	client            *WhoisClient
-	domains           []string
-	gaugeChannel      *prometheus.GaugeVec
-	gaugeDomainExpiry *prometheus.GaugeVec
+	client              *whois.Client
+	domains             []string
+	lockPolicy          *LockPolicy
+	notifier            Notifier
+	readiness           *Readiness
+	gaugeChannel        *prometheus.GaugeVec
+	gaugeDomainExpiry   *prometheus.GaugeVec
+	gaugeLockCompliance *prometheus.GaugeVec
+	gaugeLockStatus     *prometheus.GaugeVec
+	gaugeLastAttempt    *prometheus.GaugeVec
+	gaugeLastSuccess    *prometheus.GaugeVec
+	gaugeFailures       *prometheus.GaugeVec
+	gaugeLastStatus     *prometheus.GaugeVec
+	lockStatuses        map[string][]string // Required statuses present on the last poll, per domain and group.
+	lastStatuses        map[string]string   // Status of the last query, per domain.
+	failures            map[string]int      // Failed queries in a row, per domain.
 }
 
-func NewWhoisWorker(applicationNamespace string, domains []string) *WhoisWorker {
+func NewWhoisWorker(applicationNamespace string, registerer prometheus.Registerer, client *whois.Client, domains []string, lockPolicy *LockPolicy, notifier Notifier, readiness *Readiness) *WhoisWorker {
 	worker := new(WhoisWorker)
-	worker.client = NewWhoisClient(applicationNamespace)
+	worker.client = client
 	worker.domains = domains
+	worker.lockPolicy = lockPolicy
+	worker.notifier = notifier
+	worker.readiness = readiness
+	worker.lockStatuses = map[string][]string{}
+	worker.lastStatuses = map[string]string{}
+	worker.failures = map[string]int{}
 
 	labels := []string{"type"}
 	worker.gaugeChannel = prometheus.NewGaugeVec(
@@ -29,7 +52,7 @@ func NewWhoisWorker(applicationNamespace string, domains []string) *WhoisWorker
 		},
 		labels,
 	)
-	prometheus.MustRegister(worker.gaugeChannel)
+	registerer.MustRegister(worker.gaugeChannel)
 
 	labels = []string{"domain", "unit"}
 	worker.gaugeDomainExpiry = prometheus.NewGaugeVec(
@@ -40,56 +63,202 @@ func NewWhoisWorker(applicationNamespace string, domains []string) *WhoisWorker
 		},
 		labels,
 	)
-	prometheus.MustRegister(worker.gaugeDomainExpiry)
+	registerer.MustRegister(worker.gaugeDomainExpiry)
+
+	labels = []string{"domain", "group"}
+	worker.gaugeLockCompliance = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_worker_lock_compliance",
+			Help:      "Gauge for a domain carrying all required EPP statuses of its group, 1 when compliant.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugeLockCompliance)
+
+	labels = []string{"domain", "group", "status"}
+	worker.gaugeLockStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_worker_lock_status",
+			Help:      "Gauge for a required EPP status of a domain, 1 when present.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugeLockStatus)
+
+	labels = []string{"domain"}
+	worker.gaugeLastAttempt = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_worker_last_attempt_timestamp_seconds",
+			Help:      "Gauge for the last time a domain was queried.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugeLastAttempt)
+
+	worker.gaugeLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_worker_last_success_timestamp_seconds",
+			Help:      "Gauge for the last time the response for a domain was parsed successfully.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugeLastSuccess)
+
+	worker.gaugeFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_worker_consecutive_failures",
+			Help:      "Gauge for the failed queries in a row for a domain, including responses without an expiry, 0 after a success.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugeFailures)
+
+	labels = []string{"domain", "status"}
+	worker.gaugeLastStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: applicationNamespace,
+			Name:      "whois_worker_last_status",
+			Help:      "Gauge for the status of the last query for a domain, 1 for the current status.",
+		},
+		labels,
+	)
+	registerer.MustRegister(worker.gaugeLastStatus)
 	return worker
 }
 
-func (worker *WhoisWorker) DoWork() {
+// Polls the domains until ctx is done, which also cancels the queries in
+// flight. Returns once they have all come back.
+func (worker *WhoisWorker) DoWork(ctx context.Context) {
 	// Construct a channel for running whois queries in parallel.
-	queryChannel := make(chan WhoisResponse, len(worker.domains))
+	queryChannel := make(chan whois.Response, len(worker.domains))
 
 	// Have a metric to show how deep this buffer gets.
 	go func() {
-		for {
-			time.Sleep(1 * time.Second)
+		for sleepContext(ctx, 1*time.Second) {
 			worker.gaugeChannel.WithLabelValues("query_channel").Set(float64(len(queryChannel)))
 		}
 	}()
 
 	// Run the whois queries, capture how many days as a gauge per.
 	for {
-		worker.queryDomains(queryChannel)
+		pollCtx, span := tracer().Start(ctx, "whois_worker.poll", trace.WithAttributes(attribute.Int("whois.domains", len(worker.domains))))
+		worker.queryDomains(pollCtx, queryChannel)
 		for i := 0; i < len(worker.domains); i++ {
 			resp := <-queryChannel
-			//status := "unknown"
-			if resp.hasExpiration {
-				delta := -(time.Since(resp.expiration))
-				yearsRemaining := math.Round((delta.Hours()/24/365)*100) / 100
-				daysRemaining := math.Round((delta.Hours()/24)*100) / 100
-				worker.gaugeDomainExpiry.WithLabelValues(resp.domain, "years").Set(yearsRemaining)
-				worker.gaugeDomainExpiry.WithLabelValues(resp.domain, "days").Set(daysRemaining)
-				log.Printf("Queried %v, it expires in %d days!\n", resp.target, int(daysRemaining))
-			} else {
-				log.Printf("Queried %v, status is %v", resp.target, resp.status.String())
+			if ctx.Err() == nil {
+				// Cancelled queries say nothing about the domain.
+				worker.recordResponse(resp)
 			}
 		}
+		span.End()
+		if ctx.Err() != nil {
+			return
+		}
+		worker.readiness.PollCompleted()
 
 		// TODO: Make this a configuration setting?
 		pollingIntervalInMinutes := 5
-		time.Sleep(time.Duration(pollingIntervalInMinutes) * time.Minute)
+		if !sleepContext(ctx, time.Duration(pollingIntervalInMinutes)*time.Minute) {
+			return
+		}
 	}
 }
 
-func (worker *WhoisWorker) queryDomains(queryChannel chan WhoisResponse) {
+func (worker *WhoisWorker) recordResponse(resp whois.Response) {
+	worker.recordAttempt(resp, time.Now())
+	if resp.HasExpiration {
+		delta := -(time.Since(resp.Expiration))
+		yearsRemaining := math.Round((delta.Hours()/24/365)*100) / 100
+		daysRemaining := math.Round((delta.Hours()/24)*100) / 100
+		worker.gaugeDomainExpiry.WithLabelValues(resp.Target, "years").Set(yearsRemaining)
+		worker.gaugeDomainExpiry.WithLabelValues(resp.Target, "days").Set(daysRemaining)
+	}
+
+	if resp.Status == whois.ResponseOk {
+		worker.enforceLockPolicy(resp)
+	}
+}
+
+// Keeps track of how fresh the data of the domain is, so stale expiry gauges
+// can be told apart from healthy ones.
+func (worker *WhoisWorker) recordAttempt(resp whois.Response, now time.Time) {
+	domain := resp.Target
+	worker.gaugeLastAttempt.WithLabelValues(domain).Set(float64(now.Unix()))
+
+	// A registry telling us the domain is available is an answer too. An OK
+	// response without an expiry is not, the registry may have changed its
+	// format and the expiry gauge is left stale.
+	if (resp.Status == whois.ResponseOk && resp.HasExpiration) || resp.Available() {
+		worker.failures[domain] = 0
+		worker.gaugeLastSuccess.WithLabelValues(domain).Set(float64(now.Unix()))
+	} else {
+		worker.failures[domain]++
+	}
+	worker.gaugeFailures.WithLabelValues(domain).Set(float64(worker.failures[domain]))
+
+	status := resp.Status.String()
+	if previous, ok := worker.lastStatuses[domain]; ok && previous != status {
+		worker.gaugeLastStatus.DeleteLabelValues(domain, previous)
+	}
+	worker.lastStatuses[domain] = status
+	worker.gaugeLastStatus.WithLabelValues(domain, status).Set(1)
+}
+
+// Reports compliance with the required statuses and notifies when one of
+// them was present on the last poll but is gone now.
+func (worker *WhoisWorker) enforceLockPolicy(resp whois.Response) {
+	for _, result := range worker.lockPolicy.evaluate(resp.Target, resp) {
+		worker.gaugeLockCompliance.WithLabelValues(resp.Target, result.group).Set(boolToFloat(result.compliant()))
+		for _, status := range result.present {
+			worker.gaugeLockStatus.WithLabelValues(resp.Target, result.group, status).Set(1)
+		}
+		for _, status := range result.missing {
+			worker.gaugeLockStatus.WithLabelValues(resp.Target, result.group, status).Set(0)
+		}
+
+		key := resp.Target + "/" + result.group
+		removed := []string{}
+		for _, status := range worker.lockStatuses[key] {
+			if !resp.HasStatus(status) {
+				removed = append(removed, status)
+			}
+		}
+		if len(removed) > 0 {
+			worker.notifier.Notify(NewNotification("lock_policy", resp.Target, "required lock was removed", map[string]string{
+				"group":   result.group,
+				"removed": strings.Join(removed, ","),
+				"missing": strings.Join(result.missing, ","),
+			}))
+		}
+		worker.lockStatuses[key] = result.present
+	}
+}
+
+func (worker *WhoisWorker) queryDomains(ctx context.Context, queryChannel chan whois.Response) {
 	for _, domain := range worker.domains {
-		go worker.getWhoisResponse(domain, queryChannel)
+		go worker.getWhoisResponse(ctx, domain, queryChannel)
 	}
 }
 
-func (worker *WhoisWorker) getWhoisResponse(target string, channel chan WhoisResponse) {
-	resp := worker.client.Query(target)
-	if resp.err != nil {
-		log.Println("Error in query", target, resp.err.Error())
+func (worker *WhoisWorker) getWhoisResponse(ctx context.Context, target string, channel chan whois.Response) {
+	start := time.Now()
+	resp := worker.client.QueryContext(ctx, target)
+	attrs := []any{"domain", target, "server", resp.HostPort, "status", resp.Status.String(), "duration", time.Since(start)}
+	if resp.Err != nil {
+		slog.Warn("Error in query", append(attrs, "error", resp.Err)...)
+	} else if resp.HasExpiration {
+		slog.Info("Queried domain", append(attrs, "expires", resp.Expiration.Format("2006-01-02"))...)
+	} else {
+		slog.Info("Queried domain", attrs...)
+	}
+	if resp.Domain != "" && !whois.SameDomain(resp.Domain, target) {
+		// The registry answered about another domain, e.g. a partial match.
+		slog.Warn("Response is for another domain", "domain", target, "server", resp.HostPort, "parsed", resp.Domain)
 	}
 	channel <- resp
 }
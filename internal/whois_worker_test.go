@@ -1,10 +1,15 @@
 package internal
 
 import (
+	"context"
 	"log"
 	"math"
 	"testing"
 	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestWhoisWorkerCheckDomains(t *testing.T) {
@@ -13,39 +18,145 @@ func TestWhoisWorkerCheckDomains(t *testing.T) {
 		t.Errorf("expected at least four domains in configuration, found %d", len(appConfig.Domains))
 	}
 
-	whoisWorker := NewWhoisWorker(ApplicationNamespace, appConfig.Domains)
+	whoisWorker := NewWhoisWorker(testApplicationNamespace, prometheus.NewRegistry(), whoisClient, appConfig.Domains, NewLockPolicy(appConfig.LockPolicy.Groups), logNotifier{}, NewReadiness())
 	if len(whoisWorker.domains) < 4 {
 		t.Errorf("expected at least four domains in configuration, found %d", len(appConfig.Domains))
 	}
 
-	queryChannel := make(chan WhoisResponse, len(whoisWorker.domains))
-	whoisWorker.queryDomains(queryChannel)
+	queryChannel := make(chan whois.Response, len(whoisWorker.domains))
+	whoisWorker.queryDomains(context.Background(), queryChannel)
 	for i := 0; i < len(whoisWorker.domains); i++ {
 		resp := <-queryChannel
-		if resp.status == ResponseAvailable {
-			log.Printf("queried %v, status is available", resp.target)
-		} else if resp.status == ResponseError {
-			log.Printf("queried %v, status is error, %v", resp.target, resp.status.String())
-		} else if resp.status == ResponseExceededRate {
-			log.Printf("queried %v, exceeded rate with %v", resp.target, resp.refer)
-		} else if resp.status == ResponseOk {
-			if resp.hasExpiration {
-				delta := -(time.Since(resp.expiration))
+		if resp.Status == whois.ResponseAvailable {
+			log.Printf("queried %v, status is available", resp.Target)
+		} else if resp.Status == whois.ResponseError {
+			log.Printf("queried %v, status is error, %v", resp.Target, resp.Status.String())
+		} else if resp.Status == whois.ResponseExceededRate {
+			log.Printf("queried %v, exceeded rate with %v", resp.Target, resp.Refer)
+		} else if resp.Status == whois.ResponseOk {
+			if resp.HasExpiration {
+				delta := -(time.Since(resp.Expiration))
 				daysRemaining := math.Round((delta.Hours()/24)*100) / 100
-				log.Printf("queried %v, expires in %v days", resp.target, daysRemaining)
+				log.Printf("queried %v, expires in %v days", resp.Target, daysRemaining)
 			} else {
-				log.Printf("queried %v, status is ok", resp.target)
+				log.Printf("queried %v, status is ok", resp.Target)
 			}
-		} else if resp.status == ResponseUnauthorized {
-			log.Printf("queried %v, unauthorized with %v", resp.target, resp.hostPort)
-		} else if resp.status == ResponseUnknown {
-			log.Printf("queried %v, unknown with %v", resp.target, resp.refer)
+		} else if resp.Status == whois.ResponseUnauthorized {
+			log.Printf("queried %v, unauthorized with %v", resp.Target, resp.HostPort)
+		} else if resp.Status == whois.ResponseUnknown {
+			log.Printf("queried %v, unknown with %v", resp.Target, resp.Refer)
 		} else {
-			log.Printf("queried %v, unexpected status is %v", resp.target, resp.status.String())
+			log.Printf("queried %v, unexpected status is %v", resp.Target, resp.Status.String())
 		}
 
-		if resp.status == ResponseUnknown {
-			t.Errorf("queried %v, not expecting status %v", resp.target, ResponseUnknown)
+		if resp.Status == whois.ResponseUnknown {
+			t.Errorf("queried %v, not expecting status %v", resp.Target, whois.ResponseUnknown)
 		}
 	}
 }
+
+func TestWhoisWorkerEnforceLockPolicy(t *testing.T) {
+	notifier := &recordingNotifier{}
+	policy := NewLockPolicy([]lockPolicyGroup{{Name: "production", Domains: []string{"example.com"}}})
+	whoisWorker := NewWhoisWorker(testApplicationNamespace, prometheus.NewRegistry(), whoisClient, []string{"example.com"}, policy, notifier, NewReadiness())
+
+	locked := "Domain Name: example.com\n" +
+		"Domain Status: clientDeleteProhibited https://icann.org/epp#clientDeleteProhibited\n" +
+		"Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited\n" +
+		"Domain Status: clientUpdateProhibited https://icann.org/epp#clientUpdateProhibited\n"
+	unlocked := "Domain Name: example.com\n" +
+		"Domain Status: clientDeleteProhibited https://icann.org/epp#clientDeleteProhibited\n"
+
+	for _, raw := range []string{locked, locked, unlocked, unlocked} {
+		resp := whois.NewResponse()
+		resp.Target = "example.com"
+		resp.ParseRawResponse(raw)
+		whoisWorker.recordResponse(resp)
+	}
+
+	if len(notifier.notifications) != 1 {
+		t.Fatalf("expected a single notification for the removed locks, found %d", len(notifier.notifications))
+	} else if notifier.notifications[0].Fields["removed"] != "clientTransferProhibited,clientUpdateProhibited" {
+		t.Errorf("unexpected removed statuses %v", notifier.notifications[0].Fields)
+	}
+	if value := testutil.ToFloat64(whoisWorker.gaugeLockCompliance.WithLabelValues("example.com", "production")); value != 0 {
+		t.Errorf("expected example.com to be out of compliance, got %v", value)
+	}
+}
+
+func TestWhoisWorkerRecordAttempt(t *testing.T) {
+	whoisWorker := NewWhoisWorker(testApplicationNamespace, prometheus.NewRegistry(), whoisClient, []string{"example.com"}, NewLockPolicy(nil), logNotifier{}, NewReadiness())
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	ok := whois.ParseResponse("Registry Expiry Date: 2022-08-13T04:00:00Z\nDomain Name: example.com\n")
+	ok.Target = "example.com"
+	failed := whois.Response{Target: "example.com", Status: whois.ResponseError}
+	limited := whois.Response{Target: "example.com", Status: whois.ResponseExceededRate}
+
+	whoisWorker.recordAttempt(ok, start)
+	whoisWorker.recordAttempt(failed, start.Add(time.Minute))
+	whoisWorker.recordAttempt(limited, start.Add(2*time.Minute))
+
+	if value := testutil.ToFloat64(whoisWorker.gaugeLastAttempt.WithLabelValues("example.com")); value != float64(start.Add(2*time.Minute).Unix()) {
+		t.Errorf("unexpected last attempt %v", value)
+	} else if value := testutil.ToFloat64(whoisWorker.gaugeLastSuccess.WithLabelValues("example.com")); value != float64(start.Unix()) {
+		t.Errorf("unexpected last success %v", value)
+	} else if value := testutil.ToFloat64(whoisWorker.gaugeFailures.WithLabelValues("example.com")); value != 2 {
+		t.Errorf("expected two failures in a row, found %v", value)
+	}
+	if count := testutil.CollectAndCount(whoisWorker.gaugeLastStatus); count != 1 {
+		t.Errorf("expected only the last status to be reported, found %d series", count)
+	} else if value := testutil.ToFloat64(whoisWorker.gaugeLastStatus.WithLabelValues("example.com", "ExceededRate")); value != 1 {
+		t.Errorf("expected ExceededRate as the last status, found %v", value)
+	}
+
+	whoisWorker.recordAttempt(ok, start.Add(3*time.Minute))
+	if value := testutil.ToFloat64(whoisWorker.gaugeFailures.WithLabelValues("example.com")); value != 0 {
+		t.Errorf("expected failures to reset after a success, found %v", value)
+	}
+
+	// A response in a format we no longer parse is not a success.
+	unparsed := whois.ParseResponse("Domain Name: example.com\nStatus: active\n")
+	unparsed.Target = "example.com"
+	whoisWorker.recordAttempt(unparsed, start.Add(4*time.Minute))
+	if unparsed.Status != whois.ResponseOk {
+		t.Fatalf("expected an OK response, got %v", unparsed.Status)
+	} else if value := testutil.ToFloat64(whoisWorker.gaugeLastSuccess.WithLabelValues("example.com")); value != float64(start.Add(3*time.Minute).Unix()) {
+		t.Errorf("expected the last success to stay put without an expiry, found %v", value)
+	} else if value := testutil.ToFloat64(whoisWorker.gaugeFailures.WithLabelValues("example.com")); value != 1 {
+		t.Errorf("expected an OK response without an expiry to count as a failure, found %v", value)
+	}
+
+	available := whois.ParseResponse("No match for \"EXAMPLE.COM\".\n")
+	available.Target = "example.com"
+	whoisWorker.recordAttempt(available, start.Add(5*time.Minute))
+	if value := testutil.ToFloat64(whoisWorker.gaugeLastSuccess.WithLabelValues("example.com")); value != float64(start.Add(5*time.Minute).Unix()) {
+		t.Errorf("expected an available domain to count as a success, found %v", value)
+	}
+}
+
+func TestWhoisWorkerStopsWhenCancelled(t *testing.T) {
+	readiness := NewReadiness()
+	whoisWorker := NewWhoisWorker(testApplicationNamespace, prometheus.NewRegistry(), whoisClient, []string{"example.com", "gitlab.com"}, NewLockPolicy(nil), logNotifier{}, readiness)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		whoisWorker.DoWork(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the worker to stop once cancelled")
+	}
+
+	if len(whoisWorker.lastStatuses) != 0 {
+		t.Errorf("expected cancelled queries not to be recorded, found %v", whoisWorker.lastStatuses)
+	}
+	readiness.ConfigLoaded()
+	if problems := readiness.problems(); len(problems) == 0 || problems[0] != "first poll not completed" {
+		t.Errorf("expected a cancelled poll not to count as completed, got %v", problems)
+	}
+}
@@ -0,0 +1,339 @@
+// Package whois queries whois servers over the port 43 protocol, following the
+// referral of the root server to the registry of the TLD, and parses the
+// responses into a Response. Instrumentation and referral caching are
+// optional and plugged in through the Metrics and ReferralCache interfaces.
+// Queries are traced with OpenTelemetry, a no-op unless a provider is set.
+package whois
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/encoding"
+)
+
+// Default server asked before any referrals, and the port whois servers listen on.
+const (
+	DefaultRootServer = "whois.iana.org"
+	DefaultPort       = 43
+)
+
+// Default limits on reading a response, far above what registries send.
+const (
+	DefaultMaxResponseSize = 1 << 20
+	DefaultReadTimeout     = 30 * time.Second
+)
+
+// Opens connections to whois servers, satisfied by net.Dialer, SOCKS proxy
+// dialers and whoistest.Server.
+type Dialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// Remembers which server the root server referred a TLD to.
+type ReferralCache interface {
+	Get(tld string) (string, bool)
+	Put(tld string, server string)
+	Delete(tld string)
+}
+
+type Client struct {
+	metrics     Metrics
+	dialer      Dialer
+	rootServer  string            // Asked first unless the TLD has an override.
+	tldServers  map[string]string // Server to ask directly per TLD, skipping the root server.
+	serverPorts map[string]int    // Port per server when it is not 43.
+	referrals   ReferralCache     // Referrals of the root server per TLD, nil to always ask.
+	logger      *slog.Logger      // Nil for slog.Default at the time of logging.
+	retryPolicy RetryPolicy       // When a server is asked again within a hop.
+	maxSize     int               // Bytes read before the response is truncated.
+	readTimeout time.Duration     // Time allowed to read a whole response.
+	// Encoding of the responses per server, detected when missing.
+	serverEncodings map[string]encoding.Encoding
+	// Query syntax per server, the target as is when missing.
+	queryTemplates map[string]string
+	// Nil for the global provider of OpenTelemetry at the time of the query.
+	tracerProvider trace.TracerProvider
+}
+
+type Option func(*Client)
+
+// Asks server instead of whois.iana.org for referrals.
+func WithRootServer(server string) Option {
+	return func(c *Client) {
+		if server != "" {
+			c.rootServer = server
+		}
+	}
+}
+
+// Asks the server of a TLD directly, for registries the root server refers
+// incorrectly. TLDs are given without the leading dot, e.g. "es".
+func WithTLDServers(servers map[string]string) Option {
+	return func(c *Client) {
+		for tld, server := range servers {
+			c.tldServers[strings.ToLower(strings.TrimPrefix(tld, "."))] = server
+		}
+	}
+}
+
+// Connects to the servers on another port than 43.
+func WithServerPorts(ports map[string]int) Option {
+	return func(c *Client) {
+		for server, port := range ports {
+			c.serverPorts[strings.ToLower(server)] = port
+		}
+	}
+}
+
+// Opens connections with the dialer, e.g. to go through a proxy.
+func WithDialer(dialer Dialer) Option {
+	return func(c *Client) {
+		c.dialer = dialer
+	}
+}
+
+// Remembers the referrals of the root server per TLD in the cache.
+func WithReferralCache(cache ReferralCache) Option {
+	return func(c *Client) {
+		c.referrals = cache
+	}
+}
+
+// Stops reading a response after size bytes and flags it as truncated,
+// DefaultMaxResponseSize by default.
+func WithMaxResponseSize(size int) Option {
+	return func(c *Client) {
+		if size > 0 {
+			c.maxSize = size
+		}
+	}
+}
+
+// Gives up on a server that takes longer than timeout to send its response,
+// DefaultReadTimeout by default.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.readTimeout = timeout
+		}
+	}
+}
+
+// Reports every query to the metrics, NopMetrics by default.
+func WithMetrics(metrics Metrics) Option {
+	return func(c *Client) {
+		c.metrics = metrics
+	}
+}
+
+// Logs every server asked to the logger, including the raw response at the
+// debug level, slog.Default by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+func NewClient(options ...Option) *Client {
+	client := new(Client)
+	client.metrics = NopMetrics{}
+	client.dialer = &net.Dialer{Timeout: 10 * time.Second}
+	client.rootServer = DefaultRootServer
+	client.tldServers = map[string]string{}
+	client.serverPorts = map[string]int{}
+	client.serverEncodings = map[string]encoding.Encoding{}
+	client.queryTemplates = DefaultQueryTemplates()
+	client.maxSize = DefaultMaxResponseSize
+	client.readTimeout = DefaultReadTimeout
+	for _, option := range options {
+		option(client)
+	}
+	return client
+}
+
+// Performs the whois query via port 43 protocol and returns a simplied single
+// response intentionally because I'm a jerk and this is not meant to be
+// exhaustive. Targets are domains, IP addresses, CIDR prefixes or AS numbers
+// such as "AS64496".
+func (c *Client) Query(target string) Response {
+	return c.QueryContext(context.Background(), target)
+}
+
+// Like Query, giving up with the error of ctx once it is done, e.g. to abort
+// the queries in flight on shutdown.
+func (c *Client) QueryContext(ctx context.Context, target string) Response {
+	start := time.Now()
+	var resp Response
+	tld := ""
+	network, name := ParseNetwork(target)
+	if network == "" {
+		// Servers are asked in punycode, Unicode labels are sent verbatim
+		// otherwise and rarely match anything.
+		var err error
+		name, err = ToASCII(target)
+		if err != nil {
+			resp.Target = target
+			resp.Status = ResponseError
+			resp.Err = err
+			c.metrics.ObserveQuery(target, "", resp.Status, time.Since(start))
+			return resp
+		}
+		tld = TLD(name)
+	}
+	ctx, span := c.tracer().Start(ctx, "whois.Query", trace.WithAttributes(
+		attribute.String("whois.target", name),
+		attribute.String("whois.tld", tld),
+		attribute.String("whois.network", network),
+	))
+	// Networks have no TLD to look the registry up by, the root server refers
+	// them by address block every time.
+	referral, ok, cached := "", false, false
+	if network == "" {
+		referral, ok = c.tldServers[tld]
+		if !ok && c.referrals != nil {
+			referral, cached = c.referrals.Get(tld)
+			ok = cached
+		}
+	}
+	if ok {
+		// Overridden or cached, no need to ask for a referral.
+		resp = c.ask(ctx, referral, name)
+		if cached && resp.Err != nil && ctx.Err() == nil {
+			// Maybe the registry moved, ask the root server next time.
+			c.referrals.Delete(tld)
+		}
+	} else {
+		referral = c.rootServer // No referral, then its the root server.
+		resp = c.ask(ctx, referral, name)
+		if resp.Err == nil {
+			// No issues, check if a referral is sent.
+			if resp.Refer != "" {
+				// Referral found, second invocation.
+				referral = resp.Refer
+				if c.referrals != nil && network == "" {
+					c.referrals.Put(tld, referral)
+				}
+				resp = c.ask(ctx, referral, name)
+			}
+		}
+	}
+	resp.Target = target
+	c.metrics.ObserveQuery(target, referral, resp.Status, time.Since(start))
+	span.SetAttributes(attribute.String("whois.server", referral))
+	endSpan(span, resp)
+
+	return resp
+}
+
+// Last label of the target, e.g. "com" for "example.com".
+func TLD(target string) string {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(target), "."), ".")
+	return labels[len(labels)-1]
+}
+
+func (c *Client) log() *slog.Logger {
+	if c.logger == nil {
+		return slog.Default()
+	}
+	return c.logger
+}
+
+// Joins the server with its port, 43 unless overridden.
+func (c *Client) hostPort(server string) string {
+	port, ok := c.serverPorts[strings.ToLower(server)]
+	if !ok {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(server, strconv.Itoa(port))
+}
+
+// Asks a single server about the target once.
+func (c *Client) sendRequest(ctx context.Context, server string, target string) Response {
+	var resp Response
+	resp.Target = target
+	resp.HostPort = c.hostPort(server)
+
+	query := c.formatQuery(server, target)
+	ctx, span := c.tracer().Start(ctx, "whois.sendRequest", trace.WithAttributes(
+		attribute.String("whois.server", server),
+		attribute.String("whois.host_port", resp.HostPort),
+		attribute.String("whois.query", query),
+	))
+	received := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("whois.bytes", received), attribute.Bool("whois.truncated", resp.Truncated))
+		endSpan(span, resp)
+	}()
+
+	start := time.Now()
+	conn, err := c.dialer.DialContext(ctx, "tcp", resp.HostPort) // Typically host:43
+	dialed := time.Now()
+	if err != nil {
+		c.metrics.ObserveHop(server, dialed.Sub(start), 0, 0)
+		c.log().Debug("Error in dialing whois server", "domain", target, "server", resp.HostPort, "duration", dialed.Sub(start), "error", err)
+		resp.Status = ResponseError
+		resp.Err = err
+		return resp
+	}
+	defer conn.Close()
+
+	// Closing the connection unblocks the read below once ctx is done.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-finished:
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	conn.Write([]byte(query + "\r\n"))
+	buf := make([]byte, 1024)
+	result := []byte{}
+	for {
+		numBytes, readErr := conn.Read(buf)
+		sbuf := buf[0:numBytes]
+		result = append(result, sbuf...)
+		if len(result) > c.maxSize {
+			// Whatever the server is sending, it is not a whois response.
+			result = result[:c.maxSize]
+			resp.Truncated = true
+			break
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				err = readErr
+			}
+			break
+		}
+	}
+	received = len(result)
+	c.metrics.ObserveHop(server, dialed.Sub(start), time.Since(dialed), len(result))
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		c.log().Debug("Error in reading whois response", "domain", target, "server", resp.HostPort, "bytes", len(result), "duration", time.Since(start), "error", err)
+		resp.Status = ResponseError
+		resp.Err = err
+		return resp
+	}
+
+	resp.Original = result
+	resp.parseRawResponse(c.decode(server, result), c.log())
+	if resp.Truncated {
+		c.log().Warn("Truncated whois response", "domain", target, "server", resp.HostPort, "bytes", len(result))
+	}
+	c.log().Debug("Whois hop", "domain", target, "server", resp.HostPort, "query", query, "status", resp.Status.String(), "bytes", len(result), "truncated", resp.Truncated, "duration", time.Since(start), "raw", resp.Raw)
+	return resp
+}
@@ -0,0 +1,540 @@
+package whois
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois/whoistest"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Fake whois servers with canned responses modelled on the real ones, so the
+// tests run offline.
+var whoisServer *whoistest.Server
+var whois *Client
+
+const ianaExampleResponse = `% IANA WHOIS server
+% for more information on IANA, visit http://www.iana.org
+% This query returned 1 object
+
+domain:       EXAMPLE.COM
+
+organisation: Internet Assigned Numbers Authority
+
+created:      1992-01-01
+source:       IANA
+
+`
+
+const verisignGithubResponse = `   Domain Name: GITHUB.COM
+   Registry Domain ID: 1264983250_DOMAIN_COM-VRSN
+   Registrar WHOIS Server: whois.markmonitor.com
+   Registrar URL: http://www.markmonitor.com
+   Updated Date: 2020-09-08T09:18:27Z
+   Creation Date: 2007-10-09T18:20:50Z
+   Registry Expiry Date: 2022-10-09T18:20:50Z
+   Registrar: MarkMonitor Inc.
+   Registrar IANA ID: 292
+   Domain Status: clientDeleteProhibited https://icann.org/epp#clientDeleteProhibited
+   Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited
+   Domain Status: clientUpdateProhibited https://icann.org/epp#clientUpdateProhibited
+   Name Server: DNS1.P08.NSONE.NET
+   DNSSEC: unsigned
+>>> Last update of whois database: 2021-09-01T00:00:00Z <<<
+`
+
+const verisignGitlabResponse = `   Domain Name: GITLAB.COM
+   Registry Domain ID: 1773782961_DOMAIN_COM-VRSN
+   Registrar WHOIS Server: whois.gandi.net
+   Updated Date: 2020-12-11T10:04:07Z
+   Creation Date: 2013-01-15T20:29:55Z
+   Registry Expiry Date: 2025-01-15T20:29:55Z
+   Registrar: Gandi SAS
+   Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited
+>>> Last update of whois database: 2021-09-01T00:00:00Z <<<
+`
+
+const educauseExampleResponse = `This Registry database contains ONLY .EDU domains.
+
+Domain Name: EXAMPLE.EDU
+
+Registrant:
+	Example University
+	Example, XX 00000
+	USA
+
+Domain record activated:    25-Aug-1999
+Domain record last updated: 08-Jul-2021
+Domain expires:             31-Jul-2023
+
+`
+
+const pirExampleResponse = `Domain Name: EXAMPLE.ORG
+Registry Domain ID: D2328855-LROR
+Updated Date: 2021-07-01T00:00:00Z
+Creation Date: 1995-08-31T04:00:00Z
+Registry Expiry Date: 2010-08-30T04:00:00Z
+Registrar Registration Expiration Date:
+Registrar: Internet Assigned Numbers Authority
+Domain Status: serverDeleteProhibited https://icann.org/epp#serverDeleteProhibited
+`
+
+const nicEsResponse = `-------------------------------------------------------------------------------
+IP address used to perform the query not authorised for whois access.
+-------------------------------------------------------------------------------
+`
+
+func newTestWhoisServer() *whoistest.Server {
+	server := whoistest.NewServer()
+	server.Host("whois.iana.org").
+		Respond("example.com", whoistest.Text(ianaExampleResponse)).
+		Respond("example.net", whoistest.Text(strings.Replace(ianaExampleResponse, "EXAMPLE.COM", "EXAMPLE.NET", 1))).
+		Respond("example.org", whoistest.Referral("whois.pir.org")).
+		Respond("example.edu", whoistest.Referral("whois.educause.edu")).
+		Respond("example.es", whoistest.Referral("whois.nic.es")).
+		Default(whoistest.Referral("whois.verisign-grs.com"))
+	server.Host("whois.verisign-grs.com").
+		Respond("=github.com", whoistest.Text(verisignGithubResponse)).
+		Respond("=gitlab.com", whoistest.Trickle(verisignGitlabResponse, 64, time.Millisecond)).
+		Respond("=ratelimited.com", whoistest.RateLimited()).
+		Respond("=reset.com", whoistest.ResetConnection()).
+		Respond("=oversized.com", whoistest.Oversized(1<<20))
+	server.Host("whois.pir.org").Respond("example.org", whoistest.Text(pirExampleResponse))
+	server.Host("whois.educause.edu").Respond("example.edu", whoistest.Text(educauseExampleResponse))
+	server.Host("whois.nic.es").Default(whoistest.Text(nicEsResponse))
+	return server
+}
+
+func TestMain(m *testing.M) {
+	whoisServer = newTestWhoisServer()
+	whois = NewClient(WithDialer(whoisServer))
+
+	code := m.Run()
+	whoisServer.Close()
+	os.Exit(code)
+}
+
+type whoisTestData struct {
+	target        string
+	domain        string
+	hasExpiration bool
+	expiration    time.Time
+}
+
+func TestWhoisClientAvailable(t *testing.T) {
+
+	var tests = []whoisTestData{
+		{target: "somethingmadeup123.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			resp := whois.Query(tt.target)
+
+			if resp.Err != nil {
+				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.Err.Error())
+			} else if resp.Raw == "" {
+				t.Errorf("whoisQuery(%s) expected non empty raw response.", tt.target)
+			} else if resp.Target == "" {
+				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
+			} else if resp.HostPort == "" {
+				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.HostPort)
+			} else if resp.Status != ResponseAvailable {
+				t.Errorf("whoisQuery(%s) expected to be available.", tt.target)
+			}
+		})
+	}
+}
+
+func TestWhoisClientNotAvailable(t *testing.T) {
+
+	var tests = []whoisTestData{
+		{target: "example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			resp := whois.Query(tt.target)
+
+			if resp.Err != nil {
+				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.Err.Error())
+			} else if resp.Raw == "" {
+				t.Errorf("whoisQuery(%s) expected non empty raw response.", tt.target)
+			} else if resp.Target == "" {
+				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
+			} else if resp.Status == ResponseAvailable {
+				t.Errorf("whoisQuery(%s) expected to be not available. %+v", tt.target, resp.Raw)
+			} else if resp.HostPort == "" {
+				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.HostPort)
+			}
+		})
+	}
+}
+
+func TestWhoisClientExampleNoRefer(t *testing.T) {
+
+	var tests = []whoisTestData{
+		{target: "example.com", domain: "example.com"},
+		{target: "example.net", domain: "example.net"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			resp := whois.Query(tt.target)
+
+			if resp.Err != nil {
+				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.Err.Error())
+			} else if resp.HostPort == "" {
+				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.HostPort)
+			} else if resp.Target == "" {
+				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
+			} else if resp.Domain != tt.domain {
+				t.Errorf("whois.Query(%s) got domain %v, expected %v", tt.target, resp.Domain, tt.domain)
+			}
+		})
+	}
+}
+
+func TestWhoisClientExampleWithRefer(t *testing.T) {
+
+	var tests = []whoisTestData{
+		{target: "example.edu", domain: "example.edu"},
+		{target: "example.org", domain: "example.org"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			resp := whois.Query(tt.domain)
+
+			if resp.Err != nil {
+				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.Err.Error())
+			} else if resp.HostPort == "" {
+				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.HostPort)
+			} else if resp.Target == "" {
+				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
+			} else if resp.Domain != tt.domain {
+				t.Errorf("whois.Query(%s) got domain %v, expected %v", tt.target, resp.Domain, tt.domain)
+			}
+		})
+	}
+}
+
+func TestWhoisClientForExpirations(t *testing.T) {
+
+	var tests = []whoisTestData{
+		{target: "example.com", domain: "example.com", hasExpiration: false},
+		{target: "example.net", domain: "example.net", hasExpiration: false},
+		{target: "example.edu", domain: "example.edu", hasExpiration: true, expiration: time.Date(2023, 7, 31, 0, 0, 0, 0, time.UTC)},
+		{target: "example.org", domain: "example.org", hasExpiration: true, expiration: time.Date(2010, 8, 30, 0, 0, 0, 0, time.UTC)},
+		{target: "github.com", domain: "github.com", hasExpiration: true, expiration: time.Date(2022, 10, 9, 0, 0, 0, 0, time.UTC)},
+		{target: "gitlab.com", domain: "gitlab.com", hasExpiration: true, expiration: time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			resp := whois.Query(tt.target)
+
+			if resp.Err != nil {
+				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.Err.Error())
+			} else if resp.HostPort == "" {
+				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.HostPort)
+			} else if resp.Target == "" {
+				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
+			} else if resp.Domain != tt.domain {
+				t.Errorf("whois.Query(%s) domain was %v, expected %v", tt.target, resp.Domain, tt.domain)
+			} else if resp.HasExpiration != tt.hasExpiration {
+				t.Errorf("whois.Query(%s) hasExpiration was %v, expected %v", tt.target, resp.HasExpiration, tt.hasExpiration)
+			} else if resp.HasExpiration {
+				// TODO: Check year, month, day only for now.
+				if resp.Expiration.Year() != tt.expiration.Year() ||
+					resp.Expiration.Month() != tt.expiration.Month() ||
+					resp.Expiration.Day() != tt.expiration.Day() {
+					t.Errorf("whois.Query(%s) expiration was %v, expected %v", tt.target, resp.Expiration.Local(), tt.expiration.Local())
+				}
+
+			}
+		})
+	}
+}
+
+func TestWhoisClientForNotAuthorized(t *testing.T) {
+	// Apparently .es uses an unconventional whois server.
+	// https://en.wikipedia.org/wiki/.es
+	var tests = []whoisTestData{
+		{target: "example.es", domain: "example.es"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			resp := whois.Query(tt.target)
+
+			if resp.Err != nil {
+				t.Errorf("whoisQuery(%s) error %s", tt.target, resp.Err.Error())
+			} else if resp.HostPort == "" {
+				t.Errorf("whoisQuery(%s) expected non empty hostPort. %v", tt.target, resp.HostPort)
+			} else if resp.Target == "" {
+				t.Errorf("whoisQuery(%s) expected non empty target.", tt.target)
+			} else if resp.Status != ResponseUnauthorized {
+				t.Errorf("whois.Query(%s) expected to return unauthorized.", tt.target)
+			}
+		})
+	}
+}
+
+func TestWhoisClientFaultyServers(t *testing.T) {
+	var tests = []struct {
+		target string
+		status ResponseType
+	}{
+		{target: "ratelimited.com", status: ResponseExceededRate},
+		{target: "oversized.com", status: ResponseOk},
+		{target: "unknownhost.xyz", status: ResponseError},
+	}
+
+	// Unknown TLDs get referred to a host the fake does not know about.
+	whoisServer.Host("whois.iana.org").Respond("unknownhost.xyz", whoistest.Referral("whois.nic.xyz"))
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			resp := whois.Query(tt.target)
+			if resp.Status != tt.status {
+				t.Errorf("whois.Query(%s) status was %v, expected %v", tt.target, resp.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestWhoisClientConnectionReset(t *testing.T) {
+	resp := whois.Query("reset.com")
+	if resp.Raw != "" {
+		t.Errorf("whois.Query(reset.com) expected an empty raw response, got %v", resp.Raw)
+	} else if resp.Status != ResponseError {
+		t.Errorf("whois.Query(reset.com) expected an error status, got %v", resp.Status)
+	} else if resp.Err == nil {
+		t.Errorf("whois.Query(reset.com) expected the read error to be returned")
+	}
+}
+
+func TestWhoisClientResponseLimits(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	server.Host("whois.verisign-grs.com").
+		Respond("=oversized.com", whoistest.Oversized(1<<20)).
+		Respond("=slow.com", whoistest.Trickle(verisignGitlabResponse, 1, 100*time.Millisecond)).
+		Respond("=binary.com", whoistest.Text("\x16\x03\x01\x00\xa5\x01\x00\x00\xa1\x03\x03"))
+	client := NewClient(
+		WithDialer(server),
+		WithTLDServers(map[string]string{"com": "whois.verisign-grs.com"}),
+		WithMaxResponseSize(4096),
+		WithReadTimeout(200*time.Millisecond),
+	)
+
+	resp := client.Query("oversized.com")
+	if !resp.Truncated || len(resp.Raw) != 4096 {
+		t.Errorf("expected the response to be truncated at 4096 bytes, got %v bytes truncated %v", len(resp.Raw), resp.Truncated)
+	} else if resp.Status != ResponseOk {
+		t.Errorf("expected the beginning of the response to be parsed, got %v", resp.Status)
+	}
+
+	resp = client.Query("slow.com")
+	if resp.Status != ResponseError || ErrorClass(resp.Err) != ErrorTimeout {
+		t.Errorf("expected the read to time out, got %v %v", resp.Status, resp.Err)
+	}
+
+	resp = client.Query("binary.com")
+	if resp.Status != ResponseMalformed || resp.Truncated {
+		t.Errorf("expected a malformed response, got %v truncated %v", resp.Status, resp.Truncated)
+	}
+
+	// Responses right at the limit are complete.
+	resp = whois.Query("oversized.com")
+	if resp.Truncated || len(resp.Raw) != 1<<20 {
+		t.Errorf("expected the default limit to allow 1MiB, got %v bytes truncated %v", len(resp.Raw), resp.Truncated)
+	}
+}
+
+func TestWhoisClientQueryContextCancelled(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	server.Host("whois.iana.org").Respond("slow.com", whoistest.Trickle(verisignGitlabResponse, 1, 100*time.Millisecond))
+
+	client := NewClient(WithDialer(server))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	resp := client.QueryContext(ctx, "slow.com")
+	if !errors.Is(resp.Err, context.DeadlineExceeded) {
+		t.Errorf("whois.QueryContext(slow.com) expected the deadline to be exceeded, got %v", resp.Err)
+	} else if resp.Status != ResponseError {
+		t.Errorf("whois.QueryContext(slow.com) status was %v, expected %v", resp.Status, ResponseError)
+	} else if time.Since(start) > 5*time.Second {
+		t.Errorf("whois.QueryContext(slow.com) took %v, expected to give up at the deadline", time.Since(start))
+	}
+}
+
+func TestWhoisClientLogsHops(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	server.Host("whois.iana.org").Respond("example.org", whoistest.Referral("whois.pir.org"))
+	server.Host("whois.pir.org").Respond("example.org", whoistest.Text(pirExampleResponse))
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	NewClient(WithDialer(server), WithLogger(logger)).Query("example.org")
+
+	servers := []string{}
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var entry map[string]interface{}
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("unexpected error decoding log entry %s", err.Error())
+		}
+		if entry["msg"] != "Whois hop" || entry["domain"] != "example.org" || entry["raw"] == "" {
+			t.Errorf("unexpected log entry %v", entry)
+		}
+		servers = append(servers, fmt.Sprint(entry["server"]))
+	}
+	if strings.Join(servers, " ") != "whois.iana.org:43 whois.pir.org:43" {
+		t.Errorf("expected a log entry per hop, got %v", servers)
+	}
+}
+
+func TestWhoisClientLogsParseErrors(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	server.Host("whois.iana.org").Respond("example.org", whoistest.Referral("whois.pir.org"))
+	server.Host("whois.pir.org").Respond("example.org", whoistest.Text(strings.Replace(pirExampleResponse, "2010-08-30T04:00:00Z", "not a date", 1)))
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	resp := NewClient(WithDialer(server), WithLogger(logger)).Query("example.org")
+
+	if resp.HasExpiration {
+		t.Errorf("expected no expiration, got %v", resp.Expiration)
+	} else if !strings.Contains(buf.String(), `"msg":"Error in parsing expiration","domain":"example.org"`) {
+		t.Errorf("expected the parse error in the client log, got %q", buf.String())
+	}
+}
+
+func TestWhoisClientTracesHops(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	server.Host("whois.iana.org").Respond("example.org", whoistest.Referral("whois.pir.org"))
+	server.Host("whois.pir.org").Respond("example.org", whoistest.Text(pirExampleResponse))
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	NewClient(WithDialer(server), WithTracerProvider(provider)).Query("example.org")
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected a span for the query and each hop, found %d", len(spans))
+	}
+	query := spans[2]
+	if query.Name() != "whois.Query" {
+		t.Fatalf("expected the query span to end last, got %v", query.Name())
+	}
+	var tests = []struct {
+		server string
+		status string
+	}{
+		{server: "whois.iana.org", status: "OK"},
+		{server: "whois.pir.org", status: "OK"},
+	}
+	for i, tt := range tests {
+		hop := spans[i]
+		attributes := map[attribute.Key]attribute.Value{}
+		for _, kv := range hop.Attributes() {
+			attributes[kv.Key] = kv.Value
+		}
+		if hop.Name() != "whois.sendRequest" || hop.Parent().SpanID() != query.SpanContext().SpanID() {
+			t.Errorf("expected hop %d to be a child of the query, got %v", i, hop.Name())
+		} else if attributes["whois.server"].AsString() != tt.server || attributes["whois.status"].AsString() != tt.status {
+			t.Errorf("expected hop %d to %v with status %v, got %v", i, tt.server, tt.status, attributes)
+		} else if attributes["whois.bytes"].AsInt64() == 0 {
+			t.Errorf("expected hop %d to record the bytes received", i)
+		}
+	}
+}
+
+func TestWhoisClientServerOverrides(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	server.Host("whois.example-root.net").Respond("example.org", whoistest.Referral("whois.pir.org"))
+	server.Host("whois.pir.org").Respond("example.org", whoistest.Text(pirExampleResponse))
+	server.Host("whois.nic.es").Default(whoistest.Text(nicEsResponse))
+
+	client := NewClient(
+		WithDialer(server),
+		WithRootServer("whois.example-root.net"),
+		WithTLDServers(map[string]string{".ES": "whois.nic.es"}),
+		WithServerPorts(map[string]int{"whois.nic.es": 4343}),
+	)
+
+	resp := client.Query("example.org")
+	if resp.Err != nil {
+		t.Errorf("whois.Query(example.org) error %s", resp.Err.Error())
+	} else if resp.Domain != "example.org" {
+		t.Errorf("whois.Query(example.org) expected the referral from the root server to be followed, got %v", resp.Domain)
+	}
+
+	resp = client.Query("example.es")
+	if resp.HostPort != "whois.nic.es:4343" {
+		t.Errorf("whois.Query(example.es) hostPort was %v, expected whois.nic.es:4343", resp.HostPort)
+	} else if resp.Status != ResponseUnauthorized {
+		t.Errorf("whois.Query(example.es) status was %v, expected %v", resp.Status, ResponseUnauthorized)
+	}
+	if queries := server.Host("whois.example-root.net").Queries(); len(queries) != 1 {
+		t.Errorf("expected the root server to only be asked about example.org, got %v", queries)
+	}
+}
+
+type recordingMetrics struct {
+	servers  []string
+	statuses []ResponseType
+	hops     []string
+	bytes    int
+	retries  []string
+}
+
+func (m *recordingMetrics) ObserveQuery(target string, server string, status ResponseType, duration time.Duration) {
+	m.servers = append(m.servers, server)
+	m.statuses = append(m.statuses, status)
+}
+
+func (m *recordingMetrics) ObserveHop(server string, dial time.Duration, read time.Duration, bytes int) {
+	m.hops = append(m.hops, server)
+	m.bytes += bytes
+}
+
+func (m *recordingMetrics) ObserveRetry(server string, reason string) {
+	m.retries = append(m.retries, server+" "+reason)
+}
+
+func TestClientMetrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	client := NewClient(WithDialer(whoisServer), WithMetrics(metrics))
+	client.Query("github.com")
+	client.Query("example.es")
+
+	if len(metrics.servers) != 2 {
+		t.Fatalf("expected one observation per query, found %d", len(metrics.servers))
+	} else if metrics.servers[0] != "whois.verisign-grs.com" || metrics.statuses[0] != ResponseOk {
+		t.Errorf("unexpected observation %v %v", metrics.servers[0], metrics.statuses[0])
+	} else if metrics.servers[1] != "whois.nic.es" || metrics.statuses[1] != ResponseUnauthorized {
+		t.Errorf("unexpected observation %v %v", metrics.servers[1], metrics.statuses[1])
+	}
+	if len(metrics.hops) != 4 || metrics.hops[0] != "whois.iana.org" || metrics.hops[1] != "whois.verisign-grs.com" {
+		t.Errorf("expected a hop per server asked, found %v", metrics.hops)
+	} else if metrics.bytes < len(verisignGithubResponse) {
+		t.Errorf("expected at least %d bytes received, found %d", len(verisignGithubResponse), metrics.bytes)
+	}
+}
@@ -0,0 +1,97 @@
+package whois
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+)
+
+// Returns the encoding for its name or label, e.g. "euc-kr" or "koi8-r".
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	return enc, nil
+}
+
+// Decodes the responses of the servers with their encoding instead of
+// guessing it, keyed by host name.
+func WithServerEncodings(encodings map[string]encoding.Encoding) Option {
+	return func(c *Client) {
+		for server, enc := range encodings {
+			c.serverEncodings[strings.ToLower(server)] = enc
+		}
+	}
+}
+
+// Escape sequences switching ISO-2022-JP to JIS X 0208 or back to ASCII.
+var iso2022JPEscapes = [][]byte{{0x1b, '$', 'B'}, {0x1b, '$', '@'}, {0x1b, '(', 'J'}}
+
+// Guesses the encoding of a response among those seen from ccTLD servers:
+// ISO-2022-JP, EUC-KR and KOI8-R. Returns nil for UTF-8, including plain
+// ASCII, and for anything it cannot tell.
+func DetectEncoding(raw []byte) encoding.Encoding {
+	for _, escape := range iso2022JPEscapes {
+		if bytes.Contains(raw, escape) {
+			return japanese.ISO2022JP
+		}
+	}
+	if utf8.Valid(raw) {
+		return nil
+	}
+
+	// Hangul in EUC-KR comes in pairs of a lead byte from 0xb0 to 0xc8 and a
+	// trail byte from 0xa1 to 0xfe, Cyrillic in KOI8-R is 0xc0 and above.
+	high, hangul, cyrillic := 0, 0, 0
+	for i := 0; i < len(raw); i++ {
+		b := raw[i]
+		if b < 0x80 {
+			continue
+		}
+		high++
+		if b >= 0xc0 {
+			cyrillic++
+		}
+		if b >= 0xb0 && b <= 0xc8 && i+1 < len(raw) && raw[i+1] >= 0xa1 && raw[i+1] <= 0xfe {
+			hangul += 2
+			high++
+			if raw[i+1] >= 0xc0 {
+				cyrillic++
+			}
+			i++
+		}
+	}
+	switch {
+	case hangul*10 >= high*9:
+		return korean.EUCKR
+	case cyrillic*10 >= high*9:
+		return charmap.KOI8R
+	}
+	return nil
+}
+
+// Converts the response of the server to UTF-8 with its configured or
+// detected encoding, as is when there is neither.
+func (c *Client) decode(server string, raw []byte) string {
+	enc, ok := c.serverEncodings[strings.ToLower(server)]
+	if !ok {
+		enc = DetectEncoding(raw)
+	}
+	if enc == nil {
+		return string(raw)
+	}
+	text, err := enc.NewDecoder().Bytes(raw)
+	if err != nil {
+		c.log().Debug("Error in decoding whois response", "server", server, "encoding", fmt.Sprint(enc), "error", err)
+		return string(raw)
+	}
+	return string(text)
+}
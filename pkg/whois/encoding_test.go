@@ -0,0 +1,94 @@
+package whois
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/giuseppe7/diane/pkg/whois/whoistest"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+)
+
+// Registrant lines the way the .jp, .kr and .ru registries send them.
+const (
+	jprsRegistrant   = "[Domain Name]                   EXAMPLE.JP\n[登録者名]                      日本レジストリサービス株式会社\n[Registrant]                    Japan Registry Services Co.,Ltd.\n"
+	kisaRegistrant   = "도메인이름                  : example.kr\n등록인                      : 한국인터넷진흥원\nRegistrant                  : Korea Internet & Security Agency\n"
+	tcinetRegistrant = "domain:        EXAMPLE.RU\norg:           Общество с ограниченной ответственностью \"Пример\"\nregistrar:     RU-CENTER-RU\n"
+)
+
+func encode(t *testing.T, enc encoding.Encoding, text string) []byte {
+	encoded, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatalf("unexpected error encoding sample %s", err.Error())
+	}
+	return encoded
+}
+
+func TestDetectEncoding(t *testing.T) {
+	var tests = []struct {
+		name     string
+		raw      []byte
+		expected encoding.Encoding
+	}{
+		{name: "ascii", raw: []byte(verisignGithubResponse), expected: nil},
+		{name: "utf-8", raw: []byte(kisaRegistrant), expected: nil},
+		{name: "iso-2022-jp", raw: encode(t, japanese.ISO2022JP, jprsRegistrant), expected: japanese.ISO2022JP},
+		{name: "euc-kr", raw: encode(t, korean.EUCKR, kisaRegistrant), expected: korean.EUCKR},
+		{name: "koi8-r", raw: encode(t, charmap.KOI8R, tcinetRegistrant), expected: charmap.KOI8R},
+		{name: "binary", raw: []byte{0x16, 0x03, 0x01, 0x00, 0xa5, 0x01, 0x00, 0x00, 0x80, 0x90}, expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if detected := DetectEncoding(tt.raw); detected != tt.expected {
+				t.Errorf("expected %v, detected %v", tt.expected, detected)
+			}
+		})
+	}
+}
+
+func TestWhoisClientDecodesResponses(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	eucKR := encode(t, korean.EUCKR, kisaRegistrant)
+	windows1251 := encode(t, charmap.Windows1251, tcinetRegistrant)
+	server.Host("whois.kr").Respond("example.kr", whoistest.Text(string(eucKR)))
+	server.Host("whois.tcinet.ru").Respond("example.ru", whoistest.Text(string(windows1251)))
+
+	// Windows-1251 is too close to other single byte encodings to detect.
+	windows1251Encoding, err := LookupEncoding("windows-1251")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	client := NewClient(
+		WithDialer(server),
+		WithTLDServers(map[string]string{"kr": "whois.kr", "ru": "whois.tcinet.ru"}),
+		WithServerEncodings(map[string]encoding.Encoding{"WHOIS.TCINET.RU": windows1251Encoding}),
+	)
+
+	var tests = []struct {
+		target   string
+		original []byte
+		expected string
+	}{
+		{target: "example.kr", original: eucKR, expected: kisaRegistrant},
+		{target: "example.ru", original: windows1251, expected: tcinetRegistrant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			resp := client.Query(tt.target)
+			if resp.Raw != tt.expected {
+				t.Errorf("expected the response converted to UTF-8, got %q", resp.Raw)
+			} else if !bytes.Equal(resp.Original, tt.original) {
+				t.Errorf("expected the original bytes to be kept")
+			} else if resp.Status != ResponseOk {
+				t.Errorf("expected the converted response to be parsed, got %v", resp.Status)
+			}
+		})
+	}
+
+	if _, err := LookupEncoding("klingon"); err == nil {
+		t.Errorf("expected an error for an unknown encoding")
+	}
+}
@@ -0,0 +1,44 @@
+package whois
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// IDNA2008 lookup rules, also refusing empty and overlong labels.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.VerifyDNSLength(true))
+
+// Converts a domain to the ASCII form whois servers expect, punycode for
+// Unicode labels as per IDNA2008 and lower case, e.g. "xn--mller-kva.de" for
+// "Müller.de". Fails for names that are not valid domains.
+func ToASCII(domain string) (string, error) {
+	ascii, err := idnaProfile.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return "", fmt.Errorf("invalid domain %q: %w", domain, err)
+	}
+	return ascii, nil
+}
+
+// Converts a domain to its Unicode form, e.g. "müller.de" for
+// "xn--mller-kva.de". Returns the domain as is when it is not valid.
+func ToUnicode(domain string) string {
+	name := strings.TrimSuffix(domain, ".")
+	unicode, err := idnaProfile.ToUnicode(name)
+	if err != nil {
+		return domain
+	}
+	return unicode
+}
+
+// Tells whether both names are the same domain, whatever their form, e.g. the
+// domain queried and the one in the response of the registry.
+func SameDomain(a string, b string) bool {
+	asciiA, errA := ToASCII(a)
+	asciiB, errB := ToASCII(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+	}
+	return asciiA == asciiB
+}
@@ -0,0 +1,102 @@
+package whois
+
+import (
+	"testing"
+
+	"github.com/giuseppe7/diane/pkg/whois/whoistest"
+)
+
+func TestToASCII(t *testing.T) {
+	var tests = []struct {
+		domain   string
+		expected string
+		valid    bool
+	}{
+		{domain: "example.com", expected: "example.com", valid: true},
+		{domain: "EXAMPLE.com.", expected: "example.com", valid: true},
+		{domain: "müller.de", expected: "xn--mller-kva.de", valid: true},
+		{domain: "MÜLLER.DE", expected: "xn--mller-kva.de", valid: true},
+		{domain: "xn--mller-kva.de", expected: "xn--mller-kva.de", valid: true},
+		{domain: "straße.de", expected: "xn--strae-oqa.de", valid: true}, // Not mapped to ss as IDNA2003 did.
+		{domain: "例え.jp", expected: "xn--r8jz45g.jp", valid: true},
+		{domain: "", valid: false},
+		{domain: "example..com", valid: false},
+		{domain: "-example.com", valid: false},
+		{domain: "exa_mple.com", valid: false},
+		{domain: "xn--zz.com", valid: false},
+	}
+	for _, test := range tests {
+		ascii, err := ToASCII(test.domain)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("ToASCII(%q) expected an error, got %q", test.domain, ascii)
+			}
+		} else if err != nil {
+			t.Errorf("ToASCII(%q) unexpected error %s", test.domain, err.Error())
+		} else if ascii != test.expected {
+			t.Errorf("ToASCII(%q) was %q, expected %q", test.domain, ascii, test.expected)
+		}
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	var tests = []struct {
+		domain   string
+		expected string
+	}{
+		{domain: "xn--mller-kva.de", expected: "müller.de"},
+		{domain: "müller.de", expected: "müller.de"},
+		{domain: "example.com", expected: "example.com"},
+		{domain: "xn--zz.com", expected: "xn--zz.com"},
+	}
+	for _, test := range tests {
+		if unicode := ToUnicode(test.domain); unicode != test.expected {
+			t.Errorf("ToUnicode(%q) was %q, expected %q", test.domain, unicode, test.expected)
+		}
+	}
+}
+
+func TestSameDomain(t *testing.T) {
+	var tests = []struct {
+		a        string
+		b        string
+		expected bool
+	}{
+		{a: "müller.de", b: "xn--mller-kva.de", expected: true},
+		{a: "müller.de", b: "MÜLLER.DE", expected: true},
+		{a: "example.com", b: "EXAMPLE.COM.", expected: true},
+		{a: "müller.de", b: "muller.de", expected: false},
+		{a: "exa_mple.com", b: "EXA_MPLE.COM", expected: true},
+	}
+	for _, test := range tests {
+		if same := SameDomain(test.a, test.b); same != test.expected {
+			t.Errorf("SameDomain(%q, %q) was %v, expected %v", test.a, test.b, same, test.expected)
+		}
+	}
+}
+
+func TestWhoisClientQueriesPunycode(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	server.Host("whois.iana.org").Default(whoistest.Referral("whois.denic.de"))
+	server.Host("whois.denic.de").Respond("-T dn,ace xn--mller-kva.de", whoistest.Text("Domain: müller.de\nStatus: connect\n"))
+	client := NewClient(WithDialer(server))
+
+	resp := client.Query("Müller.de")
+	if resp.Err != nil {
+		t.Errorf("whois.Query(Müller.de) unexpected error %s", resp.Err.Error())
+	} else if queries := server.Host("whois.denic.de").Queries(); len(queries) != 1 || queries[0] != "-T dn,ace xn--mller-kva.de" {
+		t.Errorf("whois.Query(Müller.de) expected the registry to be asked in punycode, got %v", queries)
+	} else if resp.Target != "Müller.de" {
+		t.Errorf("whois.Query(Müller.de) target was %q, expected it as given", resp.Target)
+	} else if !SameDomain(resp.Domain, resp.Target) {
+		t.Errorf("whois.Query(Müller.de) parsed domain %q does not match the target", resp.Domain)
+	}
+
+	resp = client.Query("exa_mple.de")
+	if resp.Status != ResponseError || resp.Err == nil {
+		t.Errorf("whois.Query(exa_mple.de) expected an error for an invalid domain, got %v", resp.Status)
+	} else if queries := server.Host("whois.iana.org").Queries(); len(queries) != 1 {
+		t.Errorf("whois.Query(exa_mple.de) expected no server to be asked, got %v", queries)
+	}
+}
@@ -0,0 +1,32 @@
+package whois
+
+import (
+	"time"
+)
+
+// Receives the outcome of every query, e.g. to export it to Prometheus.
+type Metrics interface {
+	// Called once per Query with the server that answered last, the root
+	// server when there was no referral.
+	ObserveQuery(target string, server string, status ResponseType, duration time.Duration)
+	// Called once per server asked during a query, with the time it took to
+	// connect, the time spent reading the response and its size. Read and
+	// bytes are zero when the connection failed.
+	ObserveHop(server string, dial time.Duration, read time.Duration, bytes int)
+}
+
+// Optionally implemented by Metrics to be told of retries.
+type RetryObserver interface {
+	// Called before a server is asked again, with the error class or status
+	// that made the retry policy retry.
+	ObserveRetry(server string, reason string)
+}
+
+// Metrics that discards everything, the default of a Client.
+type NopMetrics struct{}
+
+func (NopMetrics) ObserveQuery(target string, server string, status ResponseType, duration time.Duration) {
+}
+
+func (NopMetrics) ObserveHop(server string, dial time.Duration, read time.Duration, bytes int) {
+}
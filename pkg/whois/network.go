@@ -0,0 +1,132 @@
+package whois
+
+import (
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of targets Query accepts besides domains, which are asked of the
+// regional internet registries the root server refers to.
+const (
+	NetworkIP   = "ip"
+	NetworkCIDR = "cidr"
+	NetworkASN  = "asn"
+)
+
+var asnPattern = regexp.MustCompile(`(?i)^as(\d+)$`)
+
+// Tells whether the target is an IP address, a CIDR prefix or an AS number
+// such as "AS64496", returning its kind and canonical form, e.g.
+// "192.0.2.0/24" for "192.0.2.1/24". The kind is empty for anything else,
+// domains included.
+func ParseNetwork(target string) (string, string) {
+	target = strings.TrimSpace(target)
+	if addr, err := netip.ParseAddr(target); err == nil {
+		return NetworkIP, addr.String()
+	}
+	if prefix, err := netip.ParsePrefix(target); err == nil {
+		return NetworkCIDR, prefix.Masked().String()
+	}
+	if match := asnPattern.FindStringSubmatch(target); match != nil {
+		asn, err := strconv.ParseUint(match[1], 10, 32)
+		if err == nil {
+			return NetworkASN, "AS" + strconv.FormatUint(asn, 10)
+		}
+	}
+	return "", ""
+}
+
+// Keys opening the object of an IP network or AS number, as ARIN names them
+// and in the RPSL of RIPE, APNIC, AFRINIC and LACNIC.
+var networkObjectPattern = regexp.MustCompile(`(?im)^[ \t]*(netrange|cidr|asnumber|inetnum|inet6num|aut-num):`)
+
+var blankLinesPattern = regexp.MustCompile(`\r?\n[ \t]*\r?\n`)
+
+// Returns the object describing the network or AS number, the last one when
+// there are several as ARIN lists the less specific networks first. Empty
+// when there is none.
+func networkObject(text string) string {
+	result := ""
+	for _, object := range blankLinesPattern.Split(text, -1) {
+		if networkObjectPattern.MatchString(object) {
+			result = object
+		}
+	}
+	return result
+}
+
+// Returns the patterns of the lines holding the keys, ignoring case, in the
+// order of the keys.
+func networkFieldPatterns(keys ...string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(keys))
+	for _, key := range keys {
+		patterns = append(patterns, regexp.MustCompile(`(?im)^[ \t]*`+regexp.QuoteMeta(key)+`:[ \t]*(\S.*?)[ \t]*\r?$`))
+	}
+	return patterns
+}
+
+var (
+	netNamePatterns         = networkFieldPatterns("netname", "asname", "as-name")
+	networkStatusPatterns   = networkFieldPatterns("nettype", "status")
+	organizationPatterns    = networkFieldPatterns("organization", "org-name", "owner")
+	orgNamePatterns         = networkFieldPatterns("orgname", "org-name")
+	descrPatterns           = networkFieldPatterns("descr")
+	networkModifiedPatterns = networkFieldPatterns("last-modified", "updated", "changed")
+)
+
+// Returns the value of the first of the keys found in the text, as given by
+// networkFieldPatterns.
+func networkField(text string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		match := re.FindStringSubmatch(text)
+		if match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// Handle ARIN appends to the organisation, e.g. "Example Org (EXAMPLE-1)".
+var handleSuffixPattern = regexp.MustCompile(`\s*\([^()]*\)$`)
+
+// Fills in the allocation record of an IP network or AS number. A response
+// holding one matched, even if it says "not found" somewhere, e.g. in the
+// terms of use.
+func (r *Response) parseNetwork(text string) {
+	object := networkObject(text)
+	if object == "" {
+		return
+	}
+	if r.Status == ResponseAvailable {
+		r.Status = ResponseOk
+	}
+	r.HasNetwork = true
+	r.NetName = networkField(object, netNamePatterns)
+	r.NetworkStatus = networkField(object, networkStatusPatterns)
+
+	// The organisation is in the object for ARIN and LACNIC, in an object of
+	// its own for RPSL registries, with the description as a last resort.
+	organization := networkField(object, organizationPatterns)
+	if organization == "" {
+		organization = networkField(text, orgNamePatterns)
+	}
+	if organization == "" {
+		organization = networkField(object, descrPatterns)
+	}
+	r.Organization = handleSuffixPattern.ReplaceAllString(organization, "")
+
+	modified, err := ParseDate(networkField(object, networkModifiedPatterns))
+	if err == nil {
+		r.HasLastModified = true
+		r.LastModified = modified
+	}
+}
+
+// Returns the last modification of the allocation record and whether there
+// was one.
+func (r *Response) LastModifiedDate() (time.Time, bool) {
+	return r.LastModified, r.HasLastModified
+}
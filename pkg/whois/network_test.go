@@ -0,0 +1,110 @@
+package whois
+
+import (
+	"testing"
+
+	"github.com/giuseppe7/diane/pkg/whois/whoistest"
+)
+
+func TestParseNetwork(t *testing.T) {
+	var tests = []struct {
+		target    string
+		kind      string
+		canonical string
+	}{
+		{target: "192.0.2.1", kind: NetworkIP, canonical: "192.0.2.1"},
+		{target: "2001:DB8::1", kind: NetworkIP, canonical: "2001:db8::1"},
+		{target: "192.0.2.1/24", kind: NetworkCIDR, canonical: "192.0.2.0/24"},
+		{target: "2001:db8::/32", kind: NetworkCIDR, canonical: "2001:db8::/32"},
+		{target: "AS64496", kind: NetworkASN, canonical: "AS64496"},
+		{target: "as064496", kind: NetworkASN, canonical: "AS64496"},
+		{target: "AS4294967296", kind: "", canonical: ""},
+		{target: "example.com", kind: "", canonical: ""},
+		{target: "as.example", kind: "", canonical: ""},
+		{target: "192.0.2.256", kind: "", canonical: ""},
+	}
+	for _, test := range tests {
+		kind, canonical := ParseNetwork(test.target)
+		if kind != test.kind || canonical != test.canonical {
+			t.Errorf("ParseNetwork(%q) was %q %q, expected %q %q", test.target, kind, canonical, test.kind, test.canonical)
+		}
+	}
+}
+
+func TestParseNetworkResponse(t *testing.T) {
+	resp := ParseResponse("% Note: no entries found in the cache\n\ninetnum:        192.0.2.0 - 192.0.2.255\nnetname:        EXAMPLE-NET\ndescr:          Example Network (Lab)\nstatus:         ASSIGNED PA\nlast-modified:  not-a-date\n")
+	if resp.Status != ResponseOk {
+		t.Errorf("status was %v, expected %v for a matched network", resp.Status, ResponseOk)
+	} else if resp.NetName != "EXAMPLE-NET" || resp.NetworkStatus != "ASSIGNED PA" {
+		t.Errorf("unexpected net name %q and status %q", resp.NetName, resp.NetworkStatus)
+	} else if len(resp.Statuses) != 0 {
+		t.Errorf("expected no EPP statuses for an allocation record, got %v", resp.Statuses)
+	} else if resp.Organization != "Example Network" {
+		t.Errorf("organization was %q, expected the description without the handle", resp.Organization)
+	} else if _, ok := resp.LastModifiedDate(); ok {
+		t.Errorf("expected no last modification for an invalid date")
+	}
+
+	resp = ParseResponse("%ERROR:101: no entries found\n%\n% No entries found in source RIPE.\n")
+	if resp.Status != ResponseAvailable || resp.NetName != "" {
+		t.Errorf("expected no record, got %v %q", resp.Status, resp.NetName)
+	}
+}
+
+// Referral cache kept in a map, for checking what gets cached.
+type mapCache struct {
+	servers map[string]string
+}
+
+func newMapCache() *mapCache {
+	return &mapCache{servers: map[string]string{}}
+}
+
+func (c *mapCache) Get(tld string) (string, bool) {
+	server, ok := c.servers[tld]
+	return server, ok
+}
+
+func (c *mapCache) Put(tld string, server string) {
+	c.servers[tld] = server
+}
+
+func (c *mapCache) Delete(tld string) {
+	delete(c.servers, tld)
+}
+
+func TestWhoisClientQueriesNetworks(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	iana := server.Host("whois.iana.org").
+		Respond("192.0.2.0/24", whoistest.Referral("whois.ripe.net")).
+		Respond("AS64496", whoistest.Referral("whois.arin.net")).
+		Default(whoistest.Referral("whois.ripe.net"))
+	ripe := server.Host("whois.ripe.net").Default(whoistest.Text("inetnum:        192.0.2.0 - 192.0.2.255\nnetname:        EXAMPLE-NET\nstatus:         ALLOCATED PA\n"))
+	server.Host("whois.arin.net").Respond("AS64496", whoistest.Text("ASNumber:       64496\nASName:         EXAMPLE-AS\nUpdated:        2012-02-24\n"))
+	cache := newMapCache()
+	client := NewClient(WithDialer(server), WithReferralCache(cache))
+
+	for _, target := range []string{"192.0.2.1/24", "192.0.2.7", "192.0.2.7"} {
+		resp := client.Query(target)
+		if resp.Err != nil {
+			t.Errorf("whois.Query(%s) unexpected error %s", target, resp.Err.Error())
+		} else if resp.Target != target || resp.NetName != "EXAMPLE-NET" {
+			t.Errorf("whois.Query(%s) was %q for %q, expected EXAMPLE-NET", target, resp.NetName, resp.Target)
+		}
+	}
+	if queries := ripe.Queries(); len(queries) != 3 || queries[0] != "192.0.2.0/24" || queries[1] != "192.0.2.7" {
+		t.Errorf("expected the canonical forms to be sent, got %v", queries)
+	} else if queries := iana.Queries(); len(queries) != 3 {
+		t.Errorf("expected the root server to be asked every time, got %v", queries)
+	} else if len(cache.servers) != 0 {
+		t.Errorf("expected no referral to be cached for networks, got %v", cache.servers)
+	}
+
+	resp := client.Query("as64496")
+	if resp.NetName != "EXAMPLE-AS" {
+		t.Errorf("whois.Query(as64496) net name was %q, expected EXAMPLE-AS", resp.NetName)
+	} else if modified, ok := resp.LastModifiedDate(); !ok || modified.Format("2006-01-02") != "2012-02-24" {
+		t.Errorf("whois.Query(as64496) last modified was %v", modified)
+	}
+}
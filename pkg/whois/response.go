@@ -0,0 +1,383 @@
+package whois
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Outcome of a whois query.
+type ResponseType int
+
+const (
+	ResponseUnknown ResponseType = iota
+	ResponseOk
+	ResponseError
+	ResponseAvailable
+	ResponseUnauthorized
+	ResponseExceededRate
+	ResponseMalformed // Binary or garbage instead of text, left unparsed.
+)
+
+var responseTypeNames = [...]string{"Unknown", "OK", "Error", "Available", "Unauthorized", "ExceededRate", "Malformed"}
+
+func (rt ResponseType) String() string {
+	if rt < 0 || int(rt) >= len(responseTypeNames) {
+		return fmt.Sprintf("ResponseType(%d)", int(rt))
+	}
+	return responseTypeNames[rt]
+}
+
+// Non-exhaustive list of values from a whois query.
+type Response struct {
+	Target   string       // What we queried, as given to Query.
+	HostPort string       // Who we queried, as host:port.
+	Raw      string       // Raw response of the last server asked, converted to UTF-8.
+	Original []byte       // Raw response as received, before any conversion.
+	Status   ResponseType // Response status using enums above.
+	Err      error        // Error caught for ResponseError use cases.
+	// The server sent more than the maximum response size, Raw holds the
+	// beginning of the response only.
+	Truncated bool
+	// Parsed values below.
+	Refer         string    // Parsed refer response in case of another query is required.
+	Domain        string    // Parsed domain in the final response, lower case.
+	HasExpiration bool      // Determines if expiry was parsed.
+	Expiration    time.Time // Actual expiry that was parsed.
+	Registrar     string    // Parsed registrar name.
+	HasCreation   bool      // Determines if creation date was parsed.
+	Creation      time.Time // Actual creation date that was parsed.
+	Statuses      []string  // Parsed EPP status codes, e.g. clientTransferProhibited.
+	// Parsed allocation record of an IP network or AS number.
+	HasNetwork      bool      // Determines if such a record was found.
+	Organization    string    // Holder of the network, e.g. from OrgName or org-name.
+	NetName         string    // Name of the network or AS, e.g. from NetName or as-name.
+	NetworkStatus   string    // Allocation status, e.g. "ALLOCATED PA" or "Direct Allocation".
+	HasLastModified bool      // Determines if the last modification was parsed.
+	LastModified    time.Time // Last modification of the record.
+}
+
+func NewResponse() Response {
+	resp := Response{}
+	resp.Status = ResponseUnknown
+	resp.HasExpiration = false
+	return resp
+}
+
+// Parses a raw response into a new Response.
+func ParseResponse(raw string) Response {
+	resp := NewResponse()
+	resp.ParseRawResponse(raw)
+	return resp
+}
+
+// Fills in the status and parsed values from the raw response of a server,
+// logging what cannot be parsed to slog.Default.
+func (r *Response) ParseRawResponse(raw string) {
+	r.parseRawResponse(raw, slog.Default())
+}
+
+// Parses the raw response as ParseRawResponse does, logging to the logger of
+// the client that queried it.
+func (r *Response) parseRawResponse(raw string, logger *slog.Logger) {
+	r.Raw = raw
+	if isMalformed(raw) {
+		r.Status = ResponseMalformed
+		return
+	}
+	r.Status = ResponseOk // Default to OK at this point unless we have a value below.
+
+	if hasRefer(raw) {
+		r.Refer = getRefer(raw)
+	}
+	if noMatchFound(raw) {
+		r.Status = ResponseAvailable
+	}
+	if hasDomain(raw) {
+		r.Domain = getDomain(raw)
+	}
+	if hasExpiration(raw) {
+		expiration, err := getExpiration(raw)
+		if err == nil {
+			r.HasExpiration = true
+			r.Expiration = expiration
+		} else {
+			logger.Warn("Error in parsing expiration", "domain", r.Domain, "error", err)
+		}
+	}
+	if hasRegistrar(raw) {
+		r.Registrar = getRegistrar(raw)
+	}
+	if hasCreation(raw) {
+		creation, err := parseDateValue(getCreation(raw))
+		if err == nil {
+			r.HasCreation = true
+			r.Creation = creation
+		}
+	}
+	r.parseNetwork(raw)
+	// The status of an allocation record, e.g. "ALLOCATED PA", is no EPP
+	// status and is kept in NetworkStatus instead.
+	if hasStatuses(raw) && !r.HasNetwork {
+		r.Statuses = getStatuses(raw)
+	}
+	if hasExceededQueries(raw) {
+		r.Status = ResponseExceededRate
+	}
+	if notAuthorized(raw) {
+		r.Status = ResponseUnauthorized
+	}
+}
+
+// Binary or garbage rather than text, e.g. from a server speaking another
+// protocol on port 43. Control characters other than whitespace and the
+// escape of ISO-2022 encodings hardly ever appear in whois text.
+func isMalformed(text string) bool {
+	if strings.IndexByte(text, 0) >= 0 {
+		return true
+	}
+	control := 0
+	for i := 0; i < len(text); i++ {
+		switch b := text[i]; {
+		case b == '\t' || b == '\n' || b == '\r' || b == '\f' || b == 0x1b:
+		case b < 0x20 || b == 0x7f:
+			control++
+		}
+	}
+	return control*10 > len(text)
+}
+
+var referKeyPattern = regexp.MustCompile(`(?i)refer:`)
+
+func hasRefer(text string) bool {
+	return referKeyPattern.MatchString(strings.TrimSpace(text))
+}
+
+var referPattern = regexp.MustCompile(`(?im)refer:[ \t]*(\S+)`)
+
+// Returns the server on the refer line, which may be the last line without a
+// trailing newline.
+func getRefer(text string) string {
+	result := ""
+	if hasRefer(text) {
+		match := referPattern.FindStringSubmatch(text)
+		if match != nil {
+			result = match[1]
+		}
+	}
+	return result
+}
+
+var noMatchPattern = regexp.MustCompile(`(?im)((no match for)|(not found)|(no data found)|(no entries found))`)
+
+func noMatchFound(text string) bool {
+	return noMatchPattern.MatchString(strings.TrimSpace(text))
+}
+
+// The domain line, either "Domain Name:" with the colon possibly padded as
+// KISA does or the bracketed "[Domain Name]" of JPRS.
+var domainPattern = regexp.MustCompile(`(?i)\s*(((domain)|(domain name))[ \t]*:|\[domain name\])\s+(.*?)\s+`)
+
+func hasDomain(text string) bool {
+	return domainPattern.MatchString(strings.TrimSpace(text))
+}
+
+func getDomain(text string) string {
+	result := ""
+	if hasDomain(text) {
+		match := domainPattern.FindStringSubmatch(strings.ToLower(text))
+		if match != nil {
+			result = strings.TrimSpace(match[5])
+		}
+	}
+	return result
+}
+
+// Keys of the expiry line, from the "Registry Expiry Date:" of gTLDs to the
+// "expire:" of CZ.NIC, the "paid-till:" of TCI and the "renewal date:" of
+// NASK. The value runs to the end of the line, which may be the last one
+// without a trailing newline.
+var expirationPattern = regexp.MustCompile(`(?im)^[ \t]*((domain expires)|(registry expiry date)|(registrar registration expiration date)|(expiry date)|(expiration date)|(expiration time)|(expire date)|(expires on)|(expires)|(expire)|(paid-till)|(renewal date))[ \t]*:[ \t]*(\S.*?)[ \t]*\r?$`)
+
+// JPRS brackets its keys and gives the expiry of a .jp domain with its state,
+// e.g. "[State] Connected (2022/08/31)", or as "[Expires on]" for the others.
+var jprsExpirationPattern = regexp.MustCompile(`(?im)^[ \t]*((\[state\][ \t]+\S+[ \t]+\(([^()]+)\))|(\[expires on\][ \t]+(\S+)))`)
+
+func hasExpiration(text string) bool {
+	return expirationPattern.MatchString(text) || jprsExpirationPattern.MatchString(text)
+}
+
+func getExpiration(text string) (time.Time, error) {
+	if match := expirationPattern.FindStringSubmatch(text); match != nil {
+		return parseDateValue(match[14])
+	}
+	if match := jprsExpirationPattern.FindStringSubmatch(text); match != nil {
+		return parseDateValue(match[3] + match[5])
+	}
+	return time.Time{}, fmt.Errorf("no expiration found")
+}
+
+// Date layouts seen in whois responses, tried in order.
+var dateLayouts = []string{
+	time.RFC3339,
+	"02-Jan-2006",
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006/01/02",
+	"2006.01.02",
+	"2006. 01. 02.",
+	"02.01.2006",
+	"20060102",
+}
+
+// Parses the date formats seen in whois responses, see dateLayouts.
+func ParseDate(text string) (time.Time, error) {
+	value := strings.TrimSpace(text)
+	for _, layout := range dateLayouts {
+		result, err := time.Parse(layout, value)
+		if err == nil {
+			return result, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", value)
+}
+
+// Parses the value of a date line, falling back to its first word for
+// values followed by a time or a remark we have no layout for, e.g.
+// "2021/09/01 01:05:06 (JST)".
+func parseDateValue(value string) (time.Time, error) {
+	result, err := ParseDate(value)
+	if err == nil {
+		return result, nil
+	}
+	fields := strings.Fields(value)
+	if len(fields) > 1 {
+		if result, fieldErr := ParseDate(fields[0]); fieldErr == nil {
+			return result, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// Keys of the creation line, including the "registered:" of CZ.NIC, the
+// padded "Registered Date :" of KISA and the bracketed keys of JPRS.
+var creationPattern = regexp.MustCompile(`(?im)^[ \t]*(((creation date)|(created)|(created on)|(registered on)|(registered)|(registered date)|(registration time))[ \t]*:|\[((registered date)|(created on))\])[ \t]*(\S.*?)[ \t]*\r?$`)
+
+func hasCreation(text string) bool {
+	return creationPattern.MatchString(text)
+}
+
+func getCreation(text string) string {
+	result := ""
+	if hasCreation(text) {
+		match := creationPattern.FindStringSubmatch(text)
+		if match != nil {
+			result = strings.TrimSpace(match[13])
+		}
+	}
+	return result
+}
+
+var registrarKeyPattern = regexp.MustCompile(`(?im)^\s*((registrar)|(sponsoring registrar)|(registrar name)):[ \t]*\S+`)
+
+var registrarPattern = regexp.MustCompile(`(?im)^\s*((registrar)|(sponsoring registrar)|(registrar name)):[ \t]*(.+?)\s*$`)
+
+func hasRegistrar(text string) bool {
+	return registrarKeyPattern.MatchString(text)
+}
+
+func getRegistrar(text string) string {
+	result := ""
+	if hasRegistrar(text) {
+		match := registrarPattern.FindStringSubmatch(text)
+		if match != nil {
+			result = strings.TrimSpace(match[5])
+		}
+	}
+	return result
+}
+
+var statusPattern = regexp.MustCompile(`(?im)^\s*((domain status)|(status)):[ \t]*([a-z]+)`)
+
+func hasStatuses(text string) bool {
+	return statusPattern.MatchString(text)
+}
+
+// EPP status codes of RFC 5731 and the grace periods of RFC 3915. Registry
+// specific statuses such as the "connect" of DENIC or the "NOT AVAILABLE" of
+// DNS Belgium are no EPP status and left out.
+var eppStatuses = []string{
+	"ok", "inactive",
+	"clientDeleteProhibited", "clientHold", "clientRenewProhibited", "clientTransferProhibited", "clientUpdateProhibited",
+	"serverDeleteProhibited", "serverHold", "serverRenewProhibited", "serverTransferProhibited", "serverUpdateProhibited",
+	"pendingCreate", "pendingDelete", "pendingRenew", "pendingRestore", "pendingTransfer", "pendingUpdate",
+	"addPeriod", "autoRenewPeriod", "renewPeriod", "transferPeriod", "redemptionPeriod",
+}
+
+// Returns the EPP status code as spelled in RFC 5731, ignoring case, and
+// whether it is one.
+func eppStatus(text string) (string, bool) {
+	for _, status := range eppStatuses {
+		if strings.EqualFold(status, text) {
+			return status, true
+		}
+	}
+	return "", false
+}
+
+// Returns the distinct EPP status codes as RFC 5731 spells them, dropping the
+// trailing ICANN links and anything that is no EPP status.
+func getStatuses(text string) []string {
+	result := []string{}
+	if hasStatuses(text) {
+		seen := map[string]bool{}
+		for _, match := range statusPattern.FindAllStringSubmatch(text, -1) {
+			status, ok := eppStatus(match[4])
+			if ok && !seen[status] {
+				seen[status] = true
+				result = append(result, status)
+			}
+		}
+	}
+	return result
+}
+
+// Reports whether the EPP status code was parsed, ignoring case.
+func (r *Response) HasStatus(status string) bool {
+	for _, s := range r.Statuses {
+		if strings.EqualFold(s, status) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the parsed expiry and whether there was one.
+func (r *Response) ExpirationDate() (time.Time, bool) {
+	return r.Expiration, r.HasExpiration
+}
+
+// Returns the parsed creation date and whether there was one.
+func (r *Response) CreationDate() (time.Time, bool) {
+	return r.Creation, r.HasCreation
+}
+
+// Reports whether the registry has no record of the target.
+func (r *Response) Available() bool {
+	return r.Status == ResponseAvailable
+}
+
+// Refusals of whois access, e.g. by ESNIC and SWITCH.
+var notAuthorizedPattern = regexp.MustCompile(`(?i)( not authorised )|(requests of this client are not permitted)`)
+
+func notAuthorized(text string) bool {
+	return notAuthorizedPattern.MatchString(strings.TrimSpace(text))
+}
+
+var exceededQueriesPattern = regexp.MustCompile(`(?i)^.*( queries exceeded.)$`)
+
+func hasExceededQueries(text string) bool {
+	return exceededQueriesPattern.MatchString(strings.TrimSpace(text))
+}
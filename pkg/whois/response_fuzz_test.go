@@ -0,0 +1,125 @@
+package whois
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Returns every registry sample of the golden corpus.
+func registrySamples(f *testing.F) []string {
+	samples, err := filepath.Glob(filepath.Join("testdata", "registries", "*.txt"))
+	if err != nil {
+		f.Fatalf("unexpected error %s", err.Error())
+	}
+	result := []string{}
+	for _, sample := range samples {
+		raw, err := os.ReadFile(sample)
+		if err != nil {
+			f.Fatalf("unexpected error %s", err.Error())
+		}
+		result = append(result, string(raw))
+	}
+	return result
+}
+
+// Seeds the fuzzer with the values of the expiry and creation lines of the
+// golden corpus, so it starts from the date formats registries really use.
+func addRegistryDates(f *testing.F) {
+	seen := map[string]bool{}
+	add := func(value string) {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			f.Add(value)
+		}
+	}
+	for _, raw := range registrySamples(f) {
+		for _, match := range expirationPattern.FindAllStringSubmatch(raw, -1) {
+			add(match[14])
+		}
+		for _, match := range jprsExpirationPattern.FindAllStringSubmatch(raw, -1) {
+			add(match[3])
+			add(match[5])
+		}
+		for _, match := range creationPattern.FindAllStringSubmatch(raw, -1) {
+			add(match[13])
+		}
+	}
+}
+
+func FuzzParseRawResponse(f *testing.F) {
+	for _, raw := range registrySamples(f) {
+		f.Add(raw)
+	}
+	f.Add(ianaExampleResponse)
+	f.Add(verisignGithubResponse)
+	f.Add(nicEsResponse)
+
+	// The parser would otherwise log every malformed date the fuzzer comes up
+	// with.
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	referLine := regexp.MustCompile(`(?im)refer:[ \t]*\S`)
+	f.Fuzz(func(t *testing.T, raw string) {
+		resp := NewResponse()
+		resp.parseRawResponse(raw, logger)
+
+		if resp.Raw != raw {
+			t.Errorf("raw response was not kept")
+		}
+		if strings.HasPrefix(resp.Status.String(), "ResponseType(") {
+			t.Errorf("unexpected status %v", resp.Status.String())
+		}
+		if strings.ContainsAny(resp.Refer, " \t\r\n") {
+			t.Errorf("refer %q contains whitespace", resp.Refer)
+		}
+		if resp.Refer == "" && referLine.MatchString(raw) && resp.Status != ResponseMalformed {
+			t.Errorf("refer was not parsed from %q", raw)
+		}
+		if resp.Domain != strings.TrimSpace(resp.Domain) {
+			t.Errorf("domain %q is not trimmed", resp.Domain)
+		}
+		for _, value := range []string{resp.Organization, resp.NetName, resp.NetworkStatus} {
+			if value != strings.TrimSpace(value) {
+				t.Errorf("network field %q is not trimmed", value)
+			}
+		}
+		seen := map[string]bool{}
+		for _, status := range resp.Statuses {
+			if seen[status] {
+				t.Errorf("status %v parsed twice", status)
+			}
+			seen[status] = true
+		}
+	})
+}
+
+func FuzzParseDate(f *testing.F) {
+	addRegistryDates(f)
+	f.Add("2021-08-14T07:01:44Z")
+	f.Add("31-Jul-2023")
+	f.Add("2022-01-10")
+	f.Add("2021.04.08")
+	f.Add("03.06.2022")
+	f.Add("20230302")
+
+	f.Fuzz(func(t *testing.T, text string) {
+		result, err := ParseDate(text)
+		if err != nil {
+			if !result.IsZero() {
+				t.Errorf("expected a zero time with error %v, got %v", err, result)
+			}
+			return
+		}
+		// Surrounding whitespace must not change the outcome.
+		padded, err := ParseDate(" \t" + text + "\r\n")
+		if err != nil {
+			t.Errorf("padded %q failed to parse: %v", text, err)
+		} else if !padded.Equal(result) {
+			t.Errorf("padded %q parsed to %v instead of %v", text, padded, result)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package whois
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Rewrites the expected output of every registry sample instead of comparing
+// against it, run with `make update-golden` after adding a sample.
+var updateGolden = flag.Bool("update-golden", false, "rewrite the golden files in testdata/registries")
+
+// What ParseRawResponse made of a registry sample, as stored in the golden files.
+type goldenResponse struct {
+	Status     string   `json:"status"`
+	Refer      string   `json:"refer,omitempty"`
+	Domain     string   `json:"domain,omitempty"`
+	Expiration string   `json:"expiration,omitempty"`
+	Registrar  string   `json:"registrar,omitempty"`
+	Creation   string   `json:"creation,omitempty"`
+	Statuses   []string `json:"statuses,omitempty"`
+	// Allocation record of IP networks and AS numbers.
+	Organization  string `json:"organization,omitempty"`
+	NetName       string `json:"net_name,omitempty"`
+	NetworkStatus string `json:"network_status,omitempty"`
+	LastModified  string `json:"last_modified,omitempty"`
+}
+
+func newGoldenResponse(resp Response) goldenResponse {
+	golden := goldenResponse{
+		Status:    resp.Status.String(),
+		Refer:     resp.Refer,
+		Domain:    resp.Domain,
+		Registrar: resp.Registrar,
+		Statuses:  resp.Statuses,
+
+		Organization:  resp.Organization,
+		NetName:       resp.NetName,
+		NetworkStatus: resp.NetworkStatus,
+	}
+	if resp.HasExpiration {
+		golden.Expiration = resp.Expiration.Format(time.RFC3339)
+	}
+	if resp.HasCreation {
+		golden.Creation = resp.Creation.Format(time.RFC3339)
+	}
+	if resp.HasLastModified {
+		golden.LastModified = resp.LastModified.Format(time.RFC3339)
+	}
+	return golden
+}
+
+// Registries whose responses lack a domain or an expiry, so missing them in
+// the golden file is expected rather than a parser gap. Anything else must
+// parse both.
+var registryKnownGaps = map[string]string{
+	"at":  "nic.at does not publish an expiry",
+	"au":  "auDA does not publish an expiry",
+	"be":  "DNS Belgium does not publish an expiry",
+	"de":  "DENIC does not publish an expiry",
+	"eu":  "EURid does not publish an expiry",
+	"gov": "the .gov registry does not publish an expiry",
+	"nl":  "SIDN does not publish an expiry",
+	"nz":  "InternetNZ does not publish an expiry",
+}
+
+func TestGoldenRegistryResponses(t *testing.T) {
+	samples, err := filepath.Glob(filepath.Join("testdata", "registries", "*.txt"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	} else if len(samples) == 0 {
+		t.Fatalf("expected registry samples in testdata/registries")
+	}
+
+	for _, sample := range samples {
+		name := strings.TrimSuffix(filepath.Base(sample), ".txt")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(sample)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err.Error())
+			}
+			resp := NewResponse()
+			resp.ParseRawResponse(string(raw))
+			if _, gap := registryKnownGaps[name]; !gap && resp.Status == ResponseOk && !resp.HasNetwork {
+				if resp.Domain == "" || !resp.HasExpiration {
+					t.Errorf("expected the domain and expiry to be parsed, found %q and %v, fix the parser or list the registry in registryKnownGaps", resp.Domain, resp.HasExpiration)
+				}
+			}
+			actual, err := json.MarshalIndent(newGoldenResponse(resp), "", "  ")
+			if err != nil {
+				t.Fatalf("unexpected error %s", err.Error())
+			}
+			actual = append(actual, '\n')
+
+			golden := strings.TrimSuffix(sample, ".txt") + ".golden.json"
+			if *updateGolden {
+				err = os.WriteFile(golden, actual, 0644)
+				if err != nil {
+					t.Fatalf("unexpected error %s", err.Error())
+				}
+				return
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("missing golden file, run make update-golden: %s", err.Error())
+			}
+			if !bytes.Equal(expected, actual) {
+				t.Errorf("parsed response differs from %v\nexpected:\n%s\nactual:\n%s", golden, expected, actual)
+			}
+		})
+	}
+}
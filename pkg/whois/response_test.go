@@ -0,0 +1,189 @@
+package whois
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewResponse(t *testing.T) {
+	resp := NewResponse()
+
+	if resp.Target != "" {
+		t.Errorf("new whoisResponse should have an empty target")
+	} else if resp.HostPort != "" {
+		t.Errorf("new whoisResponse should have an empty hostPort value")
+	} else if resp.Raw != "" {
+		t.Errorf("new whoisResponse should have an empty raw value")
+	} else if resp.Status != ResponseUnknown {
+		t.Errorf("new whoisResponse should be default to unknown")
+	} else if resp.Err != nil {
+		t.Errorf("new whoisResponse should default to not having an error")
+	} else if resp.Refer != "" {
+		t.Errorf("new whoisResponse should have an empty refer value")
+	} else if resp.HasExpiration != false {
+		t.Errorf("new whoisResponse should be default to not having an expiration")
+	}
+}
+
+func TestParseRawResponseRegistration(t *testing.T) {
+	raw := `   Domain Name: EXAMPLE.COM
+   Registry Domain ID: 2336799_DOMAIN_COM-VRSN
+   Registrar WHOIS Server: whois.iana.org
+   Updated Date: 2021-08-14T07:01:44Z
+   Creation Date: 1995-08-14T04:00:00Z
+   Registry Expiry Date: 2022-08-13T04:00:00Z
+   Registrar: RESERVED-Internet Assigned Numbers Authority
+   Registrar IANA ID: 376
+`
+	resp := NewResponse()
+	resp.ParseRawResponse(raw)
+
+	if resp.Registrar != "RESERVED-Internet Assigned Numbers Authority" {
+		t.Errorf("registrar was %v", resp.Registrar)
+	} else if !resp.HasCreation {
+		t.Errorf("expected a creation date to be parsed")
+	} else if !resp.Creation.Equal(time.Date(1995, 8, 14, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("creation was %v", resp.Creation)
+	}
+}
+
+func TestResponseTypeString(t *testing.T) {
+	var tests = []struct {
+		status   ResponseType
+		expected string
+	}{
+		{ResponseUnknown, "Unknown"},
+		{ResponseExceededRate, "ExceededRate"},
+		{ResponseMalformed, "Malformed"},
+		{ResponseType(-1), "ResponseType(-1)"},
+		{ResponseType(42), "ResponseType(42)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if actual := tt.status.String(); actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseRawResponseMalformed(t *testing.T) {
+	var tests = []struct {
+		name      string
+		raw       string
+		malformed bool
+	}{
+		{name: "text", raw: "Domain Name: EXAMPLE.COM\r\nRegistrar: RESERVED-Internet Assigned Numbers Authority\r\n\f\r\n", malformed: false},
+		{name: "iso-2022-jp", raw: "Domain Name: EXAMPLE.JP\n\x1b$B%I%a%$%s\x1b(B\n", malformed: false},
+		{name: "null bytes", raw: "Domain Name: EXAMPLE.COM\x00\n", malformed: true},
+		{name: "tls handshake", raw: "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03", malformed: true},
+		{name: "control characters", raw: "\x01\x02\x03\x04 refer: whois.example.net", malformed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ParseResponse(tt.raw)
+			if malformed := resp.Status == ResponseMalformed; malformed != tt.malformed {
+				t.Errorf("expected malformed %v, got status %v", tt.malformed, resp.Status)
+			} else if tt.malformed && (resp.Refer != "" || resp.Raw != tt.raw) {
+				t.Errorf("expected a malformed response to be kept but left unparsed, got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestResponseAccessors(t *testing.T) {
+	resp := ParseResponse("Domain Name: EXAMPLE.COM\nRegistry Expiry Date: 2022-08-13T04:00:00Z\nDomain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited\n")
+
+	if expiration, ok := resp.ExpirationDate(); !ok || !expiration.Equal(time.Date(2022, 8, 13, 4, 0, 0, 0, time.UTC)) {
+		t.Errorf("expiration was %v %v", expiration, ok)
+	} else if _, ok := resp.CreationDate(); ok {
+		t.Errorf("expected no creation date")
+	} else if !resp.HasStatus("CLIENTTRANSFERPROHIBITED") {
+		t.Errorf("expected statuses to match ignoring case, got %v", resp.Statuses)
+	} else if resp.Available() {
+		t.Errorf("expected a registered domain")
+	}
+	if resp := ParseResponse("No match for \"EXAMPLE.COM\".\n"); !resp.Available() {
+		t.Errorf("expected an available domain, status was %v", resp.Status)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected time.Time
+		valid    bool
+	}{
+		{value: "2022-08-13T04:00:00Z", expected: time.Date(2022, 8, 13, 4, 0, 0, 0, time.UTC), valid: true},
+		{value: "31-Jul-2023", expected: time.Date(2023, 7, 31, 0, 0, 0, 0, time.UTC), valid: true},
+		{value: "2022-06-30", expected: time.Date(2022, 6, 30, 0, 0, 0, 0, time.UTC), valid: true},
+		{value: "2023-03-17 12:48:36", expected: time.Date(2023, 3, 17, 12, 48, 36, 0, time.UTC), valid: true},
+		{value: "2022/08/31", expected: time.Date(2022, 8, 31, 0, 0, 0, 0, time.UTC), valid: true},
+		{value: "2022.04.23", expected: time.Date(2022, 4, 23, 0, 0, 0, 0, time.UTC), valid: true},
+		{value: "2022. 07. 18.", expected: time.Date(2022, 7, 18, 0, 0, 0, 0, time.UTC), valid: true},
+		{value: "03.06.2022", expected: time.Date(2022, 6, 3, 0, 0, 0, 0, time.UTC), valid: true},
+		{value: "20230302", expected: time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC), valid: true},
+		{value: "Tue Jan 23 2001", valid: false},
+		{value: "", valid: false},
+	}
+	for _, test := range tests {
+		result, err := ParseDate(test.value)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("ParseDate(%q) expected an error, got %v", test.value, result)
+			}
+		} else if err != nil {
+			t.Errorf("ParseDate(%q) unexpected error %s", test.value, err.Error())
+		} else if !result.Equal(test.expected) {
+			t.Errorf("ParseDate(%q) was %v, expected %v", test.value, result, test.expected)
+		}
+	}
+}
+
+func TestParseRawResponseExpiration(t *testing.T) {
+	var tests = []struct {
+		raw      string
+		expected time.Time
+	}{
+		{raw: "Domain Name: example.com\nRegistry Expiry Date: 2022-08-13T04:00:00Z", expected: time.Date(2022, 8, 13, 4, 0, 0, 0, time.UTC)},
+		{raw: "domain: example.ru\npaid-till: 2022-09-30T21:00:00Z\nfree-date: 2022-11-01\n", expected: time.Date(2022, 9, 30, 21, 0, 0, 0, time.UTC)},
+		{raw: "Domain Name : example.kr\nExpiration Date : 2022. 07. 18.\n", expected: time.Date(2022, 7, 18, 0, 0, 0, 0, time.UTC)},
+		{raw: "a. [Domain Name] EXAMPLE.JP\n[State] Connected (2022/08/31)\n", expected: time.Date(2022, 8, 31, 0, 0, 0, 0, time.UTC)},
+		{raw: "DOMAIN NAME: example.pl\nrenewal date: 2022.04.23 14:00:00\noption expiration date: 2023.01.22 13:03:28\n", expected: time.Date(2022, 4, 23, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		resp := ParseResponse(test.raw)
+		if !resp.HasExpiration {
+			t.Errorf("expected an expiration to be parsed from %q", test.raw)
+		} else if !resp.Expiration.Equal(test.expected) {
+			t.Errorf("expiration of %q was %v, expected %v", test.raw, resp.Expiration, test.expected)
+		} else if resp.Domain == "" {
+			t.Errorf("expected a domain to be parsed from %q", test.raw)
+		}
+	}
+}
+
+func TestParseRawResponseStatuses(t *testing.T) {
+	var tests = []struct {
+		raw      string
+		expected []string
+	}{
+		{raw: "Domain Status: clientTransferProhibited https://icann.org/epp#clientTransferProhibited\nDomain Status: clientTransferProhibited\n", expected: []string{"clientTransferProhibited"}},
+		{raw: "Status: SERVERHOLD\nStatus: ok\n", expected: []string{"serverHold", "ok"}},
+		{raw: "Status:\tNOT AVAILABLE\n", expected: []string{}},
+		{raw: "Status: connect\n", expected: []string{}},
+		{raw: "Status: ACTIVE\n", expected: []string{}},
+	}
+	for _, test := range tests {
+		resp := ParseResponse(test.raw)
+		if len(resp.Statuses) != len(test.expected) {
+			t.Errorf("statuses of %q were %v, expected %v", test.raw, resp.Statuses, test.expected)
+			continue
+		}
+		for i := range test.expected {
+			if resp.Statuses[i] != test.expected[i] {
+				t.Errorf("statuses of %q were %v, expected %v", test.raw, resp.Statuses, test.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package whois
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Classes of errors a retry policy can choose to retry, see ErrorClass.
+const (
+	ErrorTimeout           = "timeout"
+	ErrorConnectionReset   = "connection_reset"
+	ErrorConnectionRefused = "connection_refused"
+	ErrorDNS               = "dns"
+	ErrorCanceled          = "canceled"
+	ErrorOther             = "other"
+)
+
+// When a server is asked again within the same hop of a query. Responses
+// saying the rate limit was exceeded are never retried, asking again only
+// makes it worse.
+type RetryPolicy struct {
+	MaxAttempts       int            // Attempts per hop including the first, never retried when 1 or less.
+	Backoff           time.Duration  // Pause before the first retry, doubled for every one after it.
+	MaxBackoff        time.Duration  // Upper bound of the pause, none when 0.
+	RetryableErrors   []string       // Error classes retried, e.g. ErrorTimeout.
+	RetryableStatuses []ResponseType // Statuses of responses without errors retried, e.g. ResponseUnauthorized.
+}
+
+// Retries resets, refused connections and timeouts up to three attempts.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     3,
+		Backoff:         500 * time.Millisecond,
+		MaxBackoff:      5 * time.Second,
+		RetryableErrors: []string{ErrorTimeout, ErrorConnectionReset, ErrorConnectionRefused},
+	}
+}
+
+// Asks a server again within a hop as the policy allows, by default every
+// server is asked once.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retryPolicy = policy
+	}
+}
+
+// Sorts an error of a query into one of the classes above.
+func ErrorClass(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return ErrorCanceled
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorConnectionReset
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorConnectionRefused
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	}
+	return ErrorOther
+}
+
+// Returns the status for its name, e.g. "ExceededRate", ignoring case.
+func ParseResponseType(name string) (ResponseType, error) {
+	for i, typeName := range responseTypeNames {
+		if strings.EqualFold(typeName, name) {
+			return ResponseType(i), nil
+		}
+	}
+	return ResponseUnknown, fmt.Errorf("unknown response type %q", name)
+}
+
+// Whether the response is worth asking again for, and why.
+func (p RetryPolicy) retryable(resp Response) (string, bool) {
+	if resp.Status == ResponseExceededRate {
+		return "", false
+	}
+	if resp.Err != nil {
+		class := ErrorClass(resp.Err)
+		for _, retryable := range p.RetryableErrors {
+			if retryable == class {
+				return class, true
+			}
+		}
+		return class, false
+	}
+	for _, retryable := range p.RetryableStatuses {
+		if retryable == resp.Status {
+			return resp.Status.String(), true
+		}
+	}
+	return "", false
+}
+
+// Pause before the given retry, counting from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff == 0 || backoff < p.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+// Asks a single server about the target as the retry policy allows, one hop
+// of a query.
+func (c *Client) ask(ctx context.Context, server string, target string) Response {
+	for attempt := 1; ; attempt++ {
+		resp := c.sendRequest(ctx, server, target)
+		reason, retry := c.retryPolicy.retryable(resp)
+		if !retry || attempt >= c.retryPolicy.MaxAttempts || ctx.Err() != nil {
+			return resp
+		}
+		if observer, ok := c.metrics.(RetryObserver); ok {
+			observer.ObserveRetry(server, reason)
+		}
+		backoff := c.retryPolicy.backoff(attempt)
+		c.log().Debug("Retrying whois server", "domain", target, "server", resp.HostPort, "status", resp.Status.String(), "reason", reason, "attempt", attempt+1, "backoff", backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp
+		case <-timer.C:
+		}
+	}
+}
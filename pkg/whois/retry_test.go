@@ -0,0 +1,179 @@
+package whois
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/giuseppe7/diane/pkg/whois/whoistest"
+)
+
+func TestClientRetryPolicy(t *testing.T) {
+	var tests = []struct {
+		name     string
+		replies  []whoistest.Response
+		policy   RetryPolicy
+		status   ResponseType
+		attempts int
+		retries  []string
+	}{
+		{
+			name:     "reset then ok",
+			replies:  []whoistest.Response{whoistest.ResetConnection(), whoistest.Text(verisignGithubResponse)},
+			policy:   RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryableErrors: []string{ErrorConnectionReset}},
+			status:   ResponseOk,
+			attempts: 2,
+			retries:  []string{"whois.verisign-grs.com connection_reset"},
+		},
+		{
+			name:     "attempts exhausted",
+			replies:  []whoistest.Response{whoistest.ResetConnection()},
+			policy:   RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond, RetryableErrors: []string{ErrorConnectionReset}},
+			status:   ResponseError,
+			attempts: 2,
+			retries:  []string{"whois.verisign-grs.com connection_reset"},
+		},
+		{
+			name:     "error not retryable",
+			replies:  []whoistest.Response{whoistest.ResetConnection(), whoistest.Text(verisignGithubResponse)},
+			policy:   RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryableErrors: []string{ErrorTimeout}},
+			status:   ResponseError,
+			attempts: 1,
+		},
+		{
+			name:     "status retryable",
+			replies:  []whoistest.Response{whoistest.Text(nicEsResponse), whoistest.Text(verisignGithubResponse)},
+			policy:   RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryableStatuses: []ResponseType{ResponseUnauthorized}},
+			status:   ResponseOk,
+			attempts: 2,
+			retries:  []string{"whois.verisign-grs.com Unauthorized"},
+		},
+		{
+			name:     "rate limit never retried",
+			replies:  []whoistest.Response{whoistest.RateLimited(), whoistest.Text(verisignGithubResponse)},
+			policy:   RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryableStatuses: []ResponseType{ResponseExceededRate}},
+			status:   ResponseExceededRate,
+			attempts: 1,
+		},
+		{
+			name:     "no policy",
+			replies:  []whoistest.Response{whoistest.ResetConnection(), whoistest.Text(verisignGithubResponse)},
+			status:   ResponseError,
+			attempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := whoistest.NewServer()
+			defer server.Close()
+			registry := server.Host("whois.verisign-grs.com").RespondSequence("=github.com", tt.replies...)
+
+			metrics := &recordingMetrics{}
+			client := NewClient(WithDialer(server), WithTLDServers(map[string]string{"com": "whois.verisign-grs.com"}), WithMetrics(metrics), WithRetryPolicy(tt.policy))
+			resp := client.Query("github.com")
+			if resp.Status != tt.status {
+				t.Errorf("expected status %v, got %v with %v", tt.status, resp.Status, resp.Err)
+			} else if attempts := len(registry.Queries()); attempts != tt.attempts {
+				t.Errorf("expected %d attempts, got %d", tt.attempts, attempts)
+			} else if fmt.Sprint(metrics.retries) != fmt.Sprint(tt.retries) && len(tt.retries)+len(metrics.retries) > 0 {
+				t.Errorf("expected retries %v, got %v", tt.retries, metrics.retries)
+			}
+		})
+	}
+}
+
+// Metrics implementing only the required methods, so no RetryObserver.
+type queryOnlyMetrics struct {
+	statuses []ResponseType
+}
+
+func (m *queryOnlyMetrics) ObserveQuery(target string, server string, status ResponseType, duration time.Duration) {
+	m.statuses = append(m.statuses, status)
+}
+
+func (m *queryOnlyMetrics) ObserveHop(server string, dial time.Duration, read time.Duration, bytes int) {
+}
+
+func TestClientRetryWithoutRetryObserver(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	registry := server.Host("whois.verisign-grs.com").RespondSequence("=github.com", whoistest.ResetConnection(), whoistest.Text(verisignGithubResponse))
+
+	metrics := &queryOnlyMetrics{}
+	policy := RetryPolicy{MaxAttempts: 2, RetryableErrors: []string{ErrorConnectionReset}}
+	client := NewClient(WithDialer(server), WithTLDServers(map[string]string{"com": "whois.verisign-grs.com"}), WithMetrics(metrics), WithRetryPolicy(policy))
+	resp := client.Query("github.com")
+	if resp.Status != ResponseOk {
+		t.Errorf("expected the retry to succeed, got %v with %v", resp.Status, resp.Err)
+	} else if attempts := len(registry.Queries()); attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	} else if len(metrics.statuses) != 1 || metrics.statuses[0] != ResponseOk {
+		t.Errorf("expected the query to be observed once, got %v", metrics.statuses)
+	}
+}
+
+func TestClientRetryStopsWhenCancelled(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	server.Host("whois.verisign-grs.com").Respond("=github.com", whoistest.ResetConnection())
+
+	policy := RetryPolicy{MaxAttempts: 5, Backoff: time.Hour, RetryableErrors: []string{ErrorConnectionReset}}
+	client := NewClient(WithDialer(server), WithTLDServers(map[string]string{"com": "whois.verisign-grs.com"}), WithRetryPolicy(policy))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	resp := client.QueryContext(ctx, "github.com")
+	if resp.Status != ResponseError {
+		t.Errorf("expected an error, got %v", resp.Status)
+	} else if time.Since(start) > 5*time.Second {
+		t.Errorf("expected the backoff to end with the context, took %v", time.Since(start))
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorClass(t *testing.T) {
+	var tests = []struct {
+		err   error
+		class string
+	}{
+		{err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, class: ErrorConnectionReset},
+		{err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, class: ErrorConnectionRefused},
+		{err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "whois.nic.xyz", IsNotFound: true}}, class: ErrorDNS},
+		{err: &net.OpError{Op: "read", Err: timeoutError{}}, class: ErrorTimeout},
+		{err: context.Canceled, class: ErrorCanceled},
+		{err: fmt.Errorf("something else"), class: ErrorOther},
+	}
+	for _, tt := range tests {
+		if class := ErrorClass(tt.err); class != tt.class {
+			t.Errorf("ErrorClass(%v) was %v, expected %v", tt.err, class, tt.class)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, want := range expected {
+		if backoff := policy.backoff(i + 1); backoff != want {
+			t.Errorf("backoff(%d) was %v, expected %v", i+1, backoff, want)
+		}
+	}
+}
+
+func TestParseResponseType(t *testing.T) {
+	if status, err := ParseResponseType("exceededrate"); err != nil || status != ResponseExceededRate {
+		t.Errorf("expected ExceededRate, got %v %v", status, err)
+	}
+	if _, err := ParseResponseType("Busy"); err == nil {
+		t.Errorf("expected an error for an unknown status")
+	}
+}
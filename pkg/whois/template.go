@@ -0,0 +1,62 @@
+package whois
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Placeholder of a query template replaced with what is queried.
+const QueryPlaceholder = "{query}"
+
+// Query syntax of registries that answer plain queries poorly, keyed by host
+// name: DENIC only returns the full record for "-T dn,ace", JPRS answers in
+// Japanese unless asked with "/e" and Verisign also matches name servers and
+// registrars unless asked with "=".
+var defaultQueryTemplates = map[string]string{
+	"whois.denic.de":         "-T dn,ace {query}",
+	"whois.jprs.jp":          "{query}/e",
+	"whois.verisign-grs.com": "={query}",
+}
+
+// Returns a copy of the query templates a client uses unless overridden.
+func DefaultQueryTemplates() map[string]string {
+	templates := map[string]string{}
+	for server, template := range defaultQueryTemplates {
+		templates[server] = template
+	}
+	return templates
+}
+
+// Checks that the template holds the placeholder once and fits on a line.
+func CheckQueryTemplate(template string) error {
+	if strings.Count(template, QueryPlaceholder) != 1 {
+		return fmt.Errorf("invalid query template %q, expected %s once", template, QueryPlaceholder)
+	}
+	if strings.ContainsAny(template, "\r\n") {
+		return fmt.Errorf("invalid query template %q, expected a single line", template)
+	}
+	return nil
+}
+
+// Asks the servers with their template instead of the built-in one, keyed by
+// host name, e.g. {"whois.denic.de": "-T dn {query}"}. A template of just
+// QueryPlaceholder sends plain queries. Templates failing CheckQueryTemplate
+// are ignored.
+func WithQueryTemplates(templates map[string]string) Option {
+	return func(c *Client) {
+		for server, template := range templates {
+			if CheckQueryTemplate(template) == nil {
+				c.queryTemplates[strings.ToLower(server)] = template
+			}
+		}
+	}
+}
+
+// Line sent to the server for the target, without the line ending.
+func (c *Client) formatQuery(server string, target string) string {
+	template, ok := c.queryTemplates[strings.ToLower(server)]
+	if !ok {
+		return target
+	}
+	return strings.Replace(template, QueryPlaceholder, target, 1)
+}
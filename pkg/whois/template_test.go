@@ -0,0 +1,75 @@
+package whois
+
+import (
+	"testing"
+
+	"github.com/giuseppe7/diane/pkg/whois/whoistest"
+)
+
+func TestCheckQueryTemplate(t *testing.T) {
+	var tests = []struct {
+		template string
+		valid    bool
+	}{
+		{template: "{query}", valid: true},
+		{template: "-T dn,ace {query}", valid: true},
+		{template: "{query}/e", valid: true},
+		{template: "", valid: false},
+		{template: "example.com", valid: false},
+		{template: "{query} {query}", valid: false},
+		{template: "{query}\r\nhelp", valid: false},
+	}
+	for _, test := range tests {
+		err := CheckQueryTemplate(test.template)
+		if test.valid && err != nil {
+			t.Errorf("CheckQueryTemplate(%q) unexpected error %s", test.template, err.Error())
+		} else if !test.valid && err == nil {
+			t.Errorf("CheckQueryTemplate(%q) expected an error", test.template)
+		}
+	}
+	for server, template := range DefaultQueryTemplates() {
+		if err := CheckQueryTemplate(template); err != nil {
+			t.Errorf("built-in template of %s is invalid: %s", server, err.Error())
+		}
+	}
+}
+
+func TestWhoisClientQueryTemplates(t *testing.T) {
+	server := whoistest.NewServer()
+	defer server.Close()
+	server.Host("whois.iana.org").
+		Respond("example.de", whoistest.Referral("whois.denic.de")).
+		Respond("example.jp", whoistest.Referral("whois.jprs.jp")).
+		Respond("example.com", whoistest.Referral("whois.verisign-grs.com"))
+	denic := server.Host("whois.denic.de").Default(whoistest.Text("Domain: example.de\nStatus: connect\n"))
+	jprs := server.Host("whois.jprs.jp").Default(whoistest.Text(jprsRegistrant))
+	verisign := server.Host("whois.verisign-grs.com").Default(whoistest.Text(verisignGithubResponse))
+
+	var tests = []struct {
+		name      string
+		templates map[string]string
+		host      *whoistest.Host
+		target    string
+		expected  string
+	}{
+		{name: "denic built-in", host: denic, target: "example.de", expected: "-T dn,ace example.de"},
+		{name: "jprs built-in", host: jprs, target: "example.jp", expected: "example.jp/e"},
+		{name: "verisign built-in", host: verisign, target: "example.com", expected: "=example.com"},
+		{name: "override", templates: map[string]string{"WHOIS.DENIC.DE": "-T dn {query}"}, host: denic, target: "example.de", expected: "-T dn example.de"},
+		{name: "plain", templates: map[string]string{"whois.verisign-grs.com": "{query}"}, host: verisign, target: "example.com", expected: "example.com"},
+		{name: "invalid ignored", templates: map[string]string{"whois.jprs.jp": "/e"}, host: jprs, target: "example.jp", expected: "example.jp/e"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(WithDialer(server), WithQueryTemplates(tt.templates))
+			client.Query(tt.target)
+			queries := tt.host.Queries()
+			if len(queries) == 0 || queries[len(queries)-1] != tt.expected {
+				t.Errorf("whois.Query(%s) expected %q to be sent, got %v", tt.target, tt.expected, queries)
+			}
+		})
+	}
+	if queries := server.Host("whois.iana.org").Queries(); queries[0] != "example.de" {
+		t.Errorf("expected the root server to be asked plainly, got %v", queries)
+	}
+}
@@ -0,0 +1,36 @@
+package whois
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer recording the spans of the client.
+const tracerName = "github.com/giuseppe7/diane/pkg/whois"
+
+// Records a span per query and a child span per server asked with the
+// provider, the global provider of OpenTelemetry by default.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *Client) {
+		c.tracerProvider = provider
+	}
+}
+
+func (c *Client) tracer() trace.Tracer {
+	if c.tracerProvider == nil {
+		return otel.Tracer(tracerName)
+	}
+	return c.tracerProvider.Tracer(tracerName)
+}
+
+// Ends the span with the status of the response.
+func endSpan(span trace.Span, resp Response) {
+	span.SetAttributes(attribute.String("whois.status", resp.Status.String()))
+	if resp.Err != nil {
+		span.RecordError(resp.Err)
+		span.SetStatus(codes.Error, resp.Err.Error())
+	}
+	span.End()
+}
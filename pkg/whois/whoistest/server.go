@@ -0,0 +1,237 @@
+// Package whoistest provides a fake port 43 whois server for tests, in the
+// spirit of net/http/httptest. Every whois host name gets its own local
+// listener, and DialContext routes "host:port" addresses to them so a client
+// can be pointed at the fake instead of the real internet.
+package whoistest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Canned reply for a query.
+type Response struct {
+	Body      string        // Written back to the client.
+	ChunkSize int           // Writes the body in chunks of this many bytes, all at once when 0.
+	Delay     time.Duration // Pause before each chunk.
+	Reset     bool          // Resets the connection after the body instead of closing it.
+}
+
+// Plain reply with the body.
+func Text(body string) Response {
+	return Response{Body: body}
+}
+
+// IANA style reply referring the client to another whois server.
+func Referral(server string) Response {
+	return Text(fmt.Sprintf("%% IANA WHOIS server\n%% for more information on IANA, visit http://www.iana.org\n%% This query returned 1 object\n\nrefer:        %s\n\nsource:       IANA\n\n", server))
+}
+
+// Reply of a registry that has no record of the query.
+func NotFound(query string) Response {
+	return Text(fmt.Sprintf("No match for \"%s\".\r\n>>> Last update of whois database: 2021-09-01T00:00:00Z <<<\r\n", strings.ToUpper(query)))
+}
+
+// Reply of a registry that is rate limiting the client.
+func RateLimited() Response {
+	return Text("Number of allowed queries exceeded.\r\n")
+}
+
+// Writes the body slowly, chunkSize bytes at a time.
+func Trickle(body string, chunkSize int, delay time.Duration) Response {
+	return Response{Body: body, ChunkSize: chunkSize, Delay: delay}
+}
+
+// Resets the connection without writing anything.
+func ResetConnection() Response {
+	return Response{Reset: true}
+}
+
+// Body of size bytes made of whois-looking lines.
+func Oversized(size int) Response {
+	line := "Remarks: this line only exists to make the response large\r\n"
+	body := strings.Repeat(line, size/len(line)+1)
+	return Text(body[:size])
+}
+
+// Fake whois server for a single host name.
+type Host struct {
+	name      string
+	listener  net.Listener
+	mutex     sync.Mutex
+	responses map[string]Response
+	fallback  func(query string) Response
+	sequences map[string][]Response
+	queries   []string
+	done      sync.WaitGroup
+}
+
+// Sets the reply for the query, matched case-insensitively.
+func (h *Host) Respond(query string, resp Response) *Host {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.responses[strings.ToLower(query)] = resp
+	return h
+}
+
+// Replies to the query with each response in turn, repeating the last one,
+// e.g. to fail the first attempt only.
+func (h *Host) RespondSequence(query string, resps ...Response) *Host {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.sequences[strings.ToLower(query)] = resps
+	return h
+}
+
+// Sets the reply for queries without their own, NotFound by default.
+func (h *Host) Default(resp Response) *Host {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.fallback = func(query string) Response { return resp }
+	return h
+}
+
+// Address the host is actually listening on.
+func (h *Host) Addr() string {
+	return h.listener.Addr().String()
+}
+
+// Queries received so far, in order.
+func (h *Host) Queries() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]string{}, h.queries...)
+}
+
+func (h *Host) lookup(query string) Response {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.queries = append(h.queries, query)
+	if sequence, ok := h.sequences[strings.ToLower(query)]; ok && len(sequence) > 0 {
+		if len(sequence) > 1 {
+			h.sequences[strings.ToLower(query)] = sequence[1:]
+		}
+		return sequence[0]
+	}
+	resp, ok := h.responses[strings.ToLower(query)]
+	if !ok {
+		resp = h.fallback(query)
+	}
+	return resp
+}
+
+func (h *Host) serve() {
+	defer h.done.Done()
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+		h.done.Add(1)
+		go func() {
+			defer h.done.Done()
+			h.handle(conn)
+		}()
+	}
+}
+
+func (h *Host) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	resp := h.lookup(strings.TrimRight(line, "\r\n"))
+
+	body := []byte(resp.Body)
+	chunkSize := resp.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(body)
+	}
+	for len(body) > 0 {
+		if resp.Delay > 0 {
+			time.Sleep(resp.Delay)
+		}
+		n := chunkSize
+		if n > len(body) {
+			n = len(body)
+		}
+		_, err = conn.Write(body[:n])
+		if err != nil {
+			return
+		}
+		body = body[n:]
+	}
+
+	if resp.Reset {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			// Closing with a zero linger sends a RST instead of a FIN.
+			tcpConn.SetLinger(0)
+		}
+	}
+}
+
+// Fake whois servers keyed by host name.
+type Server struct {
+	mutex sync.Mutex
+	hosts map[string]*Host
+}
+
+func NewServer() *Server {
+	server := new(Server)
+	server.hosts = map[string]*Host{}
+	return server
+}
+
+// Returns the fake for the host name, starting it on first use.
+func (s *Server) Host(name string) *Host {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name = strings.ToLower(name)
+	if host, ok := s.hosts[name]; ok {
+		return host
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("whoistest: failed to listen: %v", err))
+	}
+	host := &Host{name: name, listener: listener, responses: map[string]Response{}, sequences: map[string][]Response{}, fallback: NotFound}
+	host.done.Add(1)
+	go host.serve()
+	s.hosts[name] = host
+	return host
+}
+
+// Dials the fake for the host of address, ignoring the port. Unknown hosts
+// fail the same way an unresolvable name would.
+func (s *Server) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	hostName, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	host, ok := s.hosts[strings.ToLower(hostName)]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: &net.DNSError{Err: "no such host", Name: hostName, IsNotFound: true}}
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, host.Addr())
+}
+
+// Stops every host and waits for their connections to finish.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, host := range s.hosts {
+		host.listener.Close()
+		host.done.Wait()
+	}
+}
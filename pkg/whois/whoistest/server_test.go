@@ -0,0 +1,128 @@
+package whoistest
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func query(t *testing.T, server *Server, address string, q string) (string, error) {
+	conn, err := server.DialContext(context.Background(), "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(q + "\r\n"))
+	body, err := io.ReadAll(conn)
+	return string(body), err
+}
+
+func TestServerRoutesByHost(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Host("whois.iana.org").Respond("example.com", Referral("whois.verisign-grs.com"))
+	server.Host("whois.verisign-grs.com").Respond("EXAMPLE.com", Text("Domain Name: EXAMPLE.COM\r\n"))
+
+	body, err := query(t, server, "whois.iana.org:43", "example.com")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	} else if !strings.Contains(body, "refer:        whois.verisign-grs.com") {
+		t.Errorf("expected a referral, got %v", body)
+	}
+
+	body, err = query(t, server, "whois.verisign-grs.com:43", "example.com")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	} else if body != "Domain Name: EXAMPLE.COM\r\n" {
+		t.Errorf("unexpected body %v", body)
+	}
+
+	body, _ = query(t, server, "whois.verisign-grs.com:43", "other.com")
+	if !strings.HasPrefix(body, `No match for "OTHER.COM".`) {
+		t.Errorf("expected unknown queries to be not found, got %v", body)
+	}
+
+	if queries := server.Host("whois.verisign-grs.com").Queries(); len(queries) != 2 || queries[1] != "other.com" {
+		t.Errorf("unexpected queries %v", queries)
+	}
+}
+
+func TestServerUnknownHost(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	_, err := query(t, server, "whois.nowhere.example:43", "example.com")
+	if err == nil {
+		t.Fatalf("expected an error dialing an unknown host")
+	}
+	if dnsErr, ok := err.(*net.OpError).Err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("expected a not found dns error, got %v", err)
+	}
+}
+
+func TestServerTrickle(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Host("whois.example").Default(Trickle("0123456789", 3, 10*time.Millisecond))
+
+	start := time.Now()
+	body, err := query(t, server, "whois.example:43", "anything")
+	if err != nil || body != "0123456789" {
+		t.Errorf("unexpected body %v error %v", body, err)
+	} else if time.Since(start) < 40*time.Millisecond {
+		t.Errorf("expected the body to trickle in, took %v", time.Since(start))
+	}
+}
+
+func TestServerReset(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Host("whois.example").Default(ResetConnection())
+
+	_, err := query(t, server, "whois.example:43", "anything")
+	if err == nil || !strings.Contains(err.Error(), "reset") {
+		t.Errorf("expected a connection reset, got %v", err)
+	}
+}
+
+func TestServerOversized(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Host("whois.example").Default(Oversized(100000))
+
+	body, err := query(t, server, "whois.example:43", "anything")
+	if err != nil || len(body) != 100000 {
+		t.Errorf("expected 100000 bytes, got %d error %v", len(body), err)
+	}
+}
+
+func TestServerRateLimited(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Host("whois.example").Default(RateLimited())
+
+	body, _ := query(t, server, "whois.example:43", "anything")
+	if !strings.Contains(body, "queries exceeded") {
+		t.Errorf("expected a rate limit message, got %v", body)
+	}
+}
+
+func TestServerRespondSequence(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Host("whois.iana.org").RespondSequence("example.com", Text("first"), Text("second"))
+
+	expected := []string{"first", "second", "second"}
+	for i, want := range expected {
+		body, err := query(t, server, "whois.iana.org:43", "example.com")
+		if err != nil {
+			t.Fatalf("unexpected error %s", err.Error())
+		} else if body != want {
+			t.Errorf("expected reply %d to be %v, got %v", i, want, body)
+		}
+	}
+}